@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	guri "github.com/tinymesh/guri/guri"
+)
+
+const configUsage = `usage: guri [flags] config <command> [args] <tty>
+
+commands:
+  dump                write configuration and calibration memory as JSON to stdout
+  apply <profile>     apply a JSON or YAML configuration profile, see -dry-run
+  backup <file>       save raw memory and module identity to file
+  restore <file>      write a backup to the module and verify, see -dry-run,
+                      -force and -restore-calibration`
+
+// arguments taken by each config command, not counting the tty path
+var configArgs = map[string]int{
+	"dump":    1,
+	"apply":   2,
+	"backup":  2,
+	"restore": 2,
+}
+
+// configCommand run `guri config ...`
+func configCommand(ctx context.Context, flags guri.Flags, args []string) error {
+	// the tty path may come from -serial instead of the last argument
+	if len(args) > 0 && len(args) == configArgs[args[0]] && "" != flags.Serial {
+		args = append(args, flags.Serial)
+	}
+
+	if len(args) < 2 {
+		return errors.New(configUsage)
+	}
+
+	// the config commands handle config mode themselves
+	flags.Verify = false
+	flags.AutoConfigure = false
+
+	cmd := args[0]
+	path := args[len(args)-1]
+
+	switch cmd {
+	case "dump":
+		remote, err := guri.ConnectSerial(ctx, path, flags)
+		if nil != err {
+			return err
+		}
+		defer remote.Close()
+
+		dump, err := guri.DumpConfiguration(ctx, remote)
+		if nil != err {
+			return err
+		}
+
+		return writeJSON(os.Stdout, dump)
+
+	case "apply":
+		if len(args) != 3 {
+			return errors.New(configUsage)
+		}
+
+		profile, err := guri.LoadProfile(args[1])
+		if nil != err {
+			return err
+		}
+
+		remote, err := guri.ConnectSerial(ctx, path, flags)
+		if nil != err {
+			return err
+		}
+		defer remote.Close()
+
+		update, err := guri.ApplyProfile(ctx, remote, profile, flags.DryRun)
+		if nil != update {
+			printUpdate(update, flags.DryRun)
+		}
+
+		return err
+
+	case "backup":
+		if len(args) != 3 {
+			return errors.New(configUsage)
+		}
+
+		remote, err := guri.ConnectSerial(ctx, path, flags)
+		if nil != err {
+			return err
+		}
+		defer remote.Close()
+
+		backup, err := guri.CreateBackup(ctx, remote)
+		if nil != err {
+			return err
+		}
+
+		if err = guri.SaveBackup(backup, args[1]); nil != err {
+			return err
+		}
+
+		fmt.Printf("saved backup of uid=%v fw=%v to %v\n", backup.UID.ToString(), backup.FWRevision, args[1])
+		return nil
+
+	case "restore":
+		if len(args) != 3 {
+			return errors.New(configUsage)
+		}
+
+		backup, err := guri.LoadBackup(args[1])
+		if nil != err {
+			return err
+		}
+
+		remote, err := guri.ConnectSerial(ctx, path, flags)
+		if nil != err {
+			return err
+		}
+		defer remote.Close()
+
+		update, err := guri.RestoreBackup(ctx, remote, backup, guri.RestoreOptions{
+			DryRun:      flags.DryRun,
+			Force:       flags.Force,
+			Calibration: flags.RestoreCalibration,
+		})
+		if nil != update {
+			printUpdate(update, flags.DryRun)
+		}
+
+		return err
+	}
+
+	return fmt.Errorf("unknown config command %q\n%v", cmd, configUsage)
+}
+
+func writeJSON(out *os.File, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func printUpdate(update *guri.MemoryUpdate, dryRun bool) {
+	action := "changed"
+	if dryRun {
+		action = "would change"
+	}
+
+	if 0 == len(update.Configuration) && 0 == len(update.Calibration) {
+		fmt.Println("module memory already up to date")
+		return
+	}
+
+	for _, change := range update.Configuration {
+		fmt.Printf("configuration %v %v\n", action, change)
+	}
+
+	for _, change := range update.Calibration {
+		fmt.Printf("calibration %v %v\n", action, change)
+	}
+}
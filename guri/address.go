@@ -2,6 +2,7 @@ package guri
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 )
 
@@ -24,6 +25,36 @@ func (addr Address) ToString() string {
 	return fmt.Sprintf("%02x:%02x:%02x:%02x", addr[0], addr[1], addr[2], addr[3])
 }
 
+// MarshalJSON encode address as "aa:bb:cc:dd"
+func (addr Address) MarshalJSON() ([]byte, error) {
+	if len(addr) != AddressLength {
+		return json.Marshal(nil)
+	}
+
+	return json.Marshal(addr.ToString())
+}
+
+// UnmarshalJSON decode address from a string accepted by ParseAddr, null as
+// written by MarshalJSON for an invalid address gives a nil address
+func (addr *Address) UnmarshalJSON(buf []byte) error {
+	if "null" == string(buf) {
+		*addr = nil
+		return nil
+	}
+
+	var str string
+
+	if err := json.Unmarshal(buf, &str); nil != err {
+		return err
+	}
+
+	if *addr = ParseAddr(str); nil == *addr {
+		return fmt.Errorf("invalid address %q", str)
+	}
+
+	return nil
+}
+
 // AddressToString []byte as address
 func AddressToString(addr []byte) string {
 	return fmt.Sprintf("%02x:%02x:%02x:%02x", addr[0], addr[1], addr[2], addr[3])
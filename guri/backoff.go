@@ -1,24 +1,162 @@
 package guri
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
 
-// Backoff ...
+// Jitter modes for BackoffPolicy
+const (
+	// JitterNone wait exactly the computed backoff
+	JitterNone = "none"
+	// JitterFull wait a random duration between 0 and the computed backoff
+	JitterFull = "full"
+	// JitterDecorrelated wait a random duration between the initial wait and
+	// the previous wait times the multiplier
+	JitterDecorrelated = "decorrelated"
+)
+
+// ErrBackoffExhausted returned by Next when the policy allows no more attempts
+var ErrBackoffExhausted = errors.New("backoff: max attempts reached")
+
+// BackoffPolicy how long to wait between reconnect attempts
+type BackoffPolicy struct {
+	Initial    time.Duration
+	Multiplier float64
+	Max        time.Duration
+	Jitter     string
+	// MaxAttempts failed attempts in a row before giving up, 0 for unlimited
+	MaxAttempts int
+}
+
+// DefaultBackoffPolicy policy used when nothing else is configured
+var DefaultBackoffPolicy = BackoffPolicy{
+	Initial:    1 * time.Second,
+	Multiplier: 2.5,
+	Max:        5 * time.Minute,
+	Jitter:     JitterFull,
+}
+
+// Validate check the policy for invalid values
+func (policy BackoffPolicy) Validate() error {
+	switch policy.Jitter {
+	case "", JitterNone, JitterFull, JitterDecorrelated:
+	default:
+		return fmt.Errorf("backoff: unknown jitter '%v', must be one of none, full or decorrelated", policy.Jitter)
+	}
+
+	if policy.Initial <= 0 {
+		return fmt.Errorf("backoff: initial wait must be positive")
+	} else if policy.Multiplier < 1 {
+		return fmt.Errorf("backoff: multiplier must be at least 1")
+	} else if policy.Max < policy.Initial {
+		return fmt.Errorf("backoff: max wait must not be less than the initial wait")
+	} else if policy.MaxAttempts < 0 {
+		return fmt.Errorf("backoff: max attempts must not be negative")
+	}
+
+	return nil
+}
+
+// Backoff tracks the wait between consecutive failed attempts
 type Backoff struct {
-	initial time.Duration
-	// data channel
-	delay float64
-	wait  time.Duration
-	// done channel, send something to close it
-	max time.Duration
+	policy BackoffPolicy
+	// wait the backoff before jitter is applied
+	wait time.Duration
+	// prev the last wait returned, used by decorrelated jitter
+	prev     time.Duration
+	attempts int
+	rand     *rand.Rand
+}
+
+// NewBackoff create a backoff following `policy`
+func NewBackoff(policy BackoffPolicy) *Backoff {
+	if policy.Initial <= 0 {
+		policy.Initial = DefaultBackoffPolicy.Initial
+	}
+
+	if policy.Multiplier < 1 {
+		policy.Multiplier = 1
+	}
+
+	if policy.Max < policy.Initial {
+		policy.Max = policy.Initial
+	}
+
+	// every process needs its own sequence, otherwise the jitter would be
+	// identical across gateways restarted at the same time
+	seed := time.Now().UnixNano() ^ int64(os.Getpid())<<32
+
+	backoff := &Backoff{
+		policy: policy,
+		rand:   rand.New(rand.NewSource(seed)),
+	}
+
+	backoff.Success()
+
+	return backoff
 }
 
-// Fail mark attempt as failed, increases backoff timer
-func (backoff *Backoff) Fail() {
-	time.Sleep(backoff.wait)
-	backoff.wait = backoff.wait * time.Duration(backoff.delay)
+// Next mark attempt as failed and return how long to wait before the next
+// one, without sleeping. Returns ErrBackoffExhausted once MaxAttempts
+// attempts have failed in a row
+func (backoff *Backoff) Next() (time.Duration, error) {
+	backoff.attempts = backoff.attempts + 1
+
+	if backoff.policy.MaxAttempts > 0 && backoff.attempts >= backoff.policy.MaxAttempts {
+		return 0, ErrBackoffExhausted
+	}
+
+	var wait time.Duration
+
+	switch backoff.policy.Jitter {
+	case JitterFull:
+		wait = backoff.between(0, backoff.wait)
+
+	case JitterDecorrelated:
+		wait = backoff.between(backoff.policy.Initial, backoff.grow(backoff.prev))
+
+	default:
+		wait = backoff.wait
+	}
+
+	backoff.wait = backoff.grow(backoff.wait)
+	backoff.prev = wait
+
+	return wait, nil
+}
+
+// Attempts number of failed attempts since the last success
+func (backoff *Backoff) Attempts() int {
+	return backoff.attempts
 }
 
 // Success mark attemp as successfull
 func (backoff *Backoff) Success() {
-	backoff.wait = backoff.initial
+	backoff.wait = backoff.policy.Initial
+	backoff.prev = backoff.policy.Initial
+	backoff.attempts = 0
+}
+
+// grow multiply `wait`, capped at the policy maximum
+func (backoff *Backoff) grow(wait time.Duration) time.Duration {
+	next := float64(wait) * backoff.policy.Multiplier
+
+	if next > float64(backoff.policy.Max) {
+		return backoff.policy.Max
+	}
+
+	return time.Duration(next)
+}
+
+// between random duration in [min, max]
+func (backoff *Backoff) between(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+
+	return min + time.Duration(backoff.rand.Int63n(int64(max-min)+1))
 }
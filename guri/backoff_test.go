@@ -0,0 +1,131 @@
+package guri
+
+import (
+	"testing"
+	"time"
+)
+
+func testPolicy(jitter string) BackoffPolicy {
+	return BackoffPolicy{
+		Initial:    1 * time.Second,
+		Multiplier: 2.5,
+		Max:        10 * time.Second,
+		Jitter:     jitter,
+	}
+}
+
+func TestBackoffNextWithoutJitter(t *testing.T) {
+	backoff := NewBackoff(testPolicy(JitterNone))
+
+	expected := []time.Duration{
+		1 * time.Second,
+		2500 * time.Millisecond,
+		6250 * time.Millisecond,
+		10 * time.Second,
+		10 * time.Second,
+	}
+
+	for i, want := range expected {
+		if wait, err := backoff.Next(); nil != err || want != wait {
+			t.Fatalf("attempt %v: waited %v (%v), expected %v", i+1, wait, err, want)
+		}
+	}
+
+	if 5 != backoff.Attempts() {
+		t.Fatalf("%v attempts, expected 5", backoff.Attempts())
+	}
+
+	backoff.Success()
+
+	if wait, _ := backoff.Next(); time.Second != wait {
+		t.Fatalf("waited %v after success, expected the initial wait", wait)
+	}
+}
+
+func TestBackoffNextBounds(t *testing.T) {
+	tests := []struct {
+		jitter string
+		min    time.Duration
+	}{
+		{JitterFull, 0},
+		{JitterDecorrelated, 1 * time.Second},
+	}
+
+	for _, test := range tests {
+		policy := testPolicy(test.jitter)
+		backoff := NewBackoff(policy)
+		ceiling := policy.Initial
+
+		for i := 0; i < 1000; i++ {
+			wait, err := backoff.Next()
+			if nil != err {
+				t.Fatal(err)
+			}
+
+			if JitterDecorrelated == test.jitter {
+				// grows from the previous wait rather than the attempt count
+				ceiling = policy.Max
+			}
+
+			if wait < test.min || wait > ceiling {
+				t.Fatalf("%v: attempt %v waited %v, expected %v-%v", test.jitter, i+1, wait, test.min, ceiling)
+			}
+
+			if ceiling = time.Duration(float64(ceiling) * policy.Multiplier); ceiling > policy.Max {
+				ceiling = policy.Max
+			}
+
+			if 0 == i%10 {
+				backoff.Success()
+				ceiling = policy.Initial
+			}
+		}
+	}
+}
+
+func TestBackoffMaxAttempts(t *testing.T) {
+	policy := testPolicy(JitterNone)
+	policy.MaxAttempts = 3
+	backoff := NewBackoff(policy)
+
+	for i := 1; i < policy.MaxAttempts; i++ {
+		if _, err := backoff.Next(); nil != err {
+			t.Fatalf("attempt %v: %v", i, err)
+		}
+	}
+
+	if _, err := backoff.Next(); ErrBackoffExhausted != err {
+		t.Fatalf("got %v after %v attempts, expected ErrBackoffExhausted", err, policy.MaxAttempts)
+	}
+
+	backoff.Success()
+
+	if _, err := backoff.Next(); nil != err {
+		t.Fatalf("got %v after success", err)
+	}
+}
+
+func TestBackoffPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*BackoffPolicy)
+		valid  bool
+	}{
+		{"default", func(*BackoffPolicy) {}, true},
+		{"no jitter given", func(policy *BackoffPolicy) { policy.Jitter = "" }, true},
+		{"unknown jitter", func(policy *BackoffPolicy) { policy.Jitter = "random" }, false},
+		{"zero initial", func(policy *BackoffPolicy) { policy.Initial = 0 }, false},
+		{"shrinking", func(policy *BackoffPolicy) { policy.Multiplier = 0.5 }, false},
+		{"max below initial", func(policy *BackoffPolicy) { policy.Max = policy.Initial / 2 }, false},
+		{"negative attempts", func(policy *BackoffPolicy) { policy.MaxAttempts = -1 }, false},
+	}
+
+	for _, test := range tests {
+		policy := DefaultBackoffPolicy
+		test.modify(&policy)
+
+		if err := policy.Validate(); test.valid != (nil == err) {
+			t.Errorf("%v: validate returned %v", test.name, err)
+		}
+	}
+}
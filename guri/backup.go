@@ -0,0 +1,198 @@
+package guri
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// Backup raw configuration and calibration memory of a module
+type Backup struct {
+	UID           Address   `json:"uid"`
+	SID           Address   `json:"sid"`
+	NID           Address   `json:"nid"`
+	HWRevision    string    `json:"hw_revision"`
+	FWRevision    string    `json:"fw_revision"`
+	Timestamp     time.Time `json:"timestamp"`
+	Configuration HexBytes  `json:"configuration"`
+	Calibration   HexBytes  `json:"calibration"`
+}
+
+// CreateBackup read memory and identity of the module on `remote`, the module
+// is left outside of config mode before returning
+func CreateBackup(ctx context.Context, remote *SerialRemote) (backup *Backup, err error) {
+	if err = leaveTinyMeshConfig(remote); nil != err {
+		return nil, err
+	}
+
+	// the revisions are nice to have, the memory dumps are what matters
+	ev, err := RequestNID(remote)
+	if nil != err {
+		log.Printf("serial:config: could not read firmware revision: %v, continuing without\n", err)
+	}
+
+	if err = WaitForTinyMeshConfig(ctx, remote); nil != err {
+		return nil, err
+	}
+
+	defer func() {
+		if exitErr := RunConfigCmd(ctx, remote, 'X', false); nil != exitErr && nil == err {
+			err = fmt.Errorf("serial:config: failed to exit configuration mode: %v", exitErr)
+		}
+	}()
+
+	cfg, err := ReadConfigMemory(ctx, remote)
+	if nil != err {
+		return nil, fmt.Errorf("serial:config: %v", err)
+	}
+
+	cal, err := ReadCalibrationMemory(ctx, remote)
+	if nil != err {
+		return nil, fmt.Errorf("serial:config: %v", err)
+	}
+
+	return &Backup{
+		UID:           cfg.UID,
+		SID:           cfg.SID,
+		NID:           cal.NID,
+		HWRevision:    formatRevision(ev.HWRevision),
+		FWRevision:    formatRevision(ev.FWRevision),
+		Timestamp:     time.Now().UTC(),
+		Configuration: cfg.Bytes(),
+		Calibration:   cal.Bytes(),
+	}, nil
+}
+
+// RestoreOptions what RestoreBackup may do
+type RestoreOptions struct {
+	// DryRun only report the changes
+	DryRun bool
+	// Force restore a backup taken from a module with a different UID, the
+	// module keeps its own UID and SID
+	Force bool
+	// Calibration also restore calibration memory, which is specific to the
+	// hardware of the module it was taken from
+	Calibration bool
+}
+
+// RestoreBackup write `backup` to the module on `remote` and verify the result.
+// Refuses backups of other modules unless forced, and leaves calibration memory
+// alone unless asked to restore it
+func RestoreBackup(ctx context.Context, remote *SerialRemote, backup *Backup, opts RestoreOptions) (update *MemoryUpdate, err error) {
+	if err = backup.Validate(); nil != err {
+		return nil, err
+	}
+
+	if err = WaitForTinyMeshConfig(ctx, remote); nil != err {
+		return nil, err
+	}
+
+	defer func() {
+		if exitErr := RunConfigCmd(ctx, remote, 'X', false); nil != exitErr && nil == err {
+			err = fmt.Errorf("serial:config: failed to exit configuration mode: %v", exitErr)
+		}
+	}()
+
+	cfg, err := ReadConfigMemory(ctx, remote)
+	if nil != err {
+		return nil, fmt.Errorf("serial:config: %v", err)
+	}
+
+	cal, err := ReadCalibrationMemory(ctx, remote)
+	if nil != err {
+		return nil, fmt.Errorf("serial:config: %v", err)
+	}
+
+	if !bytes.Equal(cfg.UID, backup.UID) {
+		if !opts.Force {
+			return nil, fmt.Errorf("serial:config: backup is of uid=%v but the module is uid=%v, refusing to restore without force", backup.UID.ToString(), cfg.UID.ToString())
+		}
+
+		log.Printf("serial:config: restoring backup of uid=%v onto uid=%v (forced), keeping the uid and sid of the module\n", backup.UID.ToString(), cfg.UID.ToString())
+	}
+
+	log.Printf("serial:config: restoring backup of uid=%v from %v\n", backup.UID.ToString(), backup.Timestamp)
+
+	// the identity of the module is never taken from a backup
+	target, _ := ParseConfigMemory(backup.Configuration)
+	target.UID = cfg.UID
+	target.SID = cfg.SID
+
+	calTarget := cal.Bytes()
+	if opts.Calibration {
+		calTarget = backup.Calibration
+	}
+
+	return UpdateMemory(ctx, remote, cfg, cal, target.Bytes(), calTarget, opts.DryRun)
+}
+
+// SaveBackup write `backup` to `path` as JSON
+func SaveBackup(backup *Backup, path string) error {
+	buf, err := json.MarshalIndent(backup, "", "  ")
+	if nil != err {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(buf, '\n'), 0600)
+}
+
+// LoadBackup read a backup written by SaveBackup
+func LoadBackup(path string) (*Backup, error) {
+	buf, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+
+	backup := &Backup{}
+	if err = json.Unmarshal(buf, backup); nil != err {
+		return nil, fmt.Errorf("backup: failed to parse %v: %v", path, err)
+	} else if err = backup.Validate(); nil != err {
+		return nil, fmt.Errorf("backup: %v: %v", path, err)
+	}
+
+	return backup, nil
+}
+
+// Validate check that the identity and both memory dumps are present
+func (backup *Backup) Validate() error {
+	for _, field := range []struct {
+		name string
+		addr Address
+	}{
+		{"uid", backup.UID},
+		{"sid", backup.SID},
+		{"nid", backup.NID},
+	} {
+		if len(field.addr) != AddressLength {
+			return fmt.Errorf("missing or invalid %v", field.name)
+		}
+	}
+
+	if _, err := ParseConfigMemory(backup.Configuration); nil != err {
+		return err
+	} else if _, err := ParseCalibrationMemory(backup.Calibration); nil != err {
+		return err
+	}
+
+	return nil
+}
+
+// BackupPath file name for a backup of `backup` in directory `dir`
+func BackupPath(dir string, backup *Backup) string {
+	return filepath.Join(dir, fmt.Sprintf("guri-%02x%02x%02x%02x-%v.json",
+		backup.UID[0], backup.UID[1], backup.UID[2], backup.UID[3],
+		backup.Timestamp.Format("20060102T150405Z")))
+}
+
+func formatRevision(rev []byte) string {
+	if len(rev) != 2 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d.%02d", rev[0], rev[1])
+}
@@ -0,0 +1,126 @@
+package guri
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testBackup() *Backup {
+	cfg := make([]byte, ConfigMemorySize+4)
+	cal := make([]byte, CalibrationMemorySize+4)
+
+	for i := range cfg {
+		cfg[i] = byte(i)
+	}
+
+	for i := range cal {
+		cal[i] = byte(100 + i)
+	}
+
+	return &Backup{
+		UID:           Address{1, 2, 3, 4},
+		SID:           Address{5, 6, 7, 8},
+		NID:           Address{9, 10, 11, 12},
+		HWRevision:    "1.02",
+		FWRevision:    "1.40",
+		Timestamp:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Configuration: cfg,
+		Calibration:   cal,
+	}
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.json")
+	backup := testBackup()
+
+	if err := SaveBackup(backup, path); nil != err {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadBackup(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if !backup.UID.Equal(loaded.UID) || !backup.SID.Equal(loaded.SID) || !backup.NID.Equal(loaded.NID) ||
+		backup.HWRevision != loaded.HWRevision || backup.FWRevision != loaded.FWRevision ||
+		!backup.Timestamp.Equal(loaded.Timestamp) ||
+		!bytes.Equal(backup.Configuration, loaded.Configuration) || !bytes.Equal(backup.Calibration, loaded.Calibration) {
+		t.Fatalf("loaded %+v, expected %+v", loaded, backup)
+	}
+
+	if name := filepath.Base(BackupPath("", loaded)); "guri-01020304-20240102T030405Z.json" != name {
+		t.Fatalf("backup named %v", name)
+	}
+}
+
+func TestLoadBackupInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(string) string
+		err    string
+	}{
+		{"missing uid", func(buf string) string {
+			return strings.Replace(buf, `"uid": "01:02:03:04",`, "", 1)
+		}, "uid"},
+		{"null sid", func(buf string) string {
+			return strings.Replace(buf, `"05:06:07:08"`, "null", 1)
+		}, "sid"},
+		{"invalid nid", func(buf string) string {
+			return strings.Replace(buf, `"09:0a:0b:0c"`, `"09:0a:zz"`, 1)
+		}, "invalid address"},
+		{"short configuration", func(buf string) string {
+			return strings.Replace(buf, `"configuration": "00`, `"configuration": "00", "unused": "`, 1)
+		}, "configuration"},
+		{"no calibration", func(buf string) string {
+			return strings.Replace(buf, `"calibration": "`, `"unused": "`, 1)
+		}, "calibration"},
+		{"not hex", func(buf string) string {
+			return strings.Replace(buf, `"configuration": "00`, `"configuration": "zz`, 1)
+		}, "invalid byte"},
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup.json")
+
+	if err := SaveBackup(testBackup(), path); nil != err {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		broken := filepath.Join(dir, "broken.json")
+
+		if err := ioutil.WriteFile(broken, []byte(test.modify(string(buf))), 0600); nil != err {
+			t.Fatal(err)
+		}
+
+		if _, err := LoadBackup(broken); nil == err || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: loaded with %v, expected an error about %v", test.name, err, test.err)
+		}
+	}
+}
+
+// an invalid address is written as null and must read back the same way
+func TestAddressJSONNull(t *testing.T) {
+	backup := testBackup()
+	backup.NID = nil
+
+	path := filepath.Join(t.TempDir(), "backup.json")
+
+	if err := SaveBackup(backup, path); nil != err {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadBackup(path); nil == err || !strings.Contains(err.Error(), "nid") {
+		t.Fatalf("loaded with %v, expected a missing nid", err)
+	}
+}
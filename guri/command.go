@@ -0,0 +1,133 @@
+package guri
+
+import (
+	"fmt"
+)
+
+// Packet types used for packets sent to a Tinymesh gateway
+const (
+	PacketTypeCommand       = 3
+	PacketTypeSerialCommand = 17
+)
+
+// Commands understood by a Tinymesh gateway, placed at offset 7 of a command
+const (
+	CmdSetOutput      = 1
+	CmdSetPWM         = 2
+	CmdInitConfig     = 3
+	CmdInitGwConfig   = 5
+	CmdRouterReset    = 6
+	CmdGetNID         = 16
+	CmdGetStatus      = 17
+	CmdGetDIDStatus   = 18
+	CmdGetConfig      = 19
+	CmdGetCalibration = 20
+)
+
+// MaxSerialPayload maximum number of bytes in a single serial command
+const MaxSerialPayload = 120
+
+// Command a Tinymesh command addressed to the node with unique id `UID`, the
+// gateway itself is addressed with 00:00:00:00
+type Command struct {
+	UID Address
+	// Number is echoed back in the ack/nak event for this command
+	Number byte
+	Cmd    byte
+	P1     byte
+	P2     byte
+}
+
+// Encode []byte representation of `cmd`
+func (cmd Command) Encode() ([]byte, error) {
+	if len(cmd.UID) != AddressLength {
+		return nil, fmt.Errorf("tinymesh:command: address must be %v bytes, got %v", AddressLength, len(cmd.UID))
+	}
+
+	return []byte{
+		10,
+		cmd.UID[0], cmd.UID[1], cmd.UID[2], cmd.UID[3],
+		cmd.Number,
+		PacketTypeCommand,
+		cmd.Cmd,
+		cmd.P1,
+		cmd.P2,
+	}, nil
+}
+
+// SerialCmd []bytes for sending `data` out on the serial port of node `uid`
+func SerialCmd(uid Address, number byte, data []byte) ([]byte, error) {
+	if len(uid) != AddressLength {
+		return nil, fmt.Errorf("tinymesh:command: address must be %v bytes, got %v", AddressLength, len(uid))
+	} else if 0 == len(data) || len(data) > MaxSerialPayload {
+		return nil, fmt.Errorf("tinymesh:command: serial payload must be 1-%v bytes, got %v", MaxSerialPayload, len(data))
+	}
+
+	buf := []byte{
+		byte(7 + len(data)),
+		uid[0], uid[1], uid[2], uid[3],
+		number,
+		PacketTypeSerialCommand,
+	}
+
+	return append(buf, data...), nil
+}
+
+// SetOutputCmd []bytes for set_output; bits in `set` are turned on and bits in
+// `clear` are turned off
+func SetOutputCmd(uid Address, number byte, set byte, clear byte) ([]byte, error) {
+	if 0 != set&clear {
+		return nil, fmt.Errorf("tinymesh:command: outputs %08b both set and cleared", set&clear)
+	}
+
+	return Command{UID: uid, Number: number, Cmd: CmdSetOutput, P1: set, P2: clear}.Encode()
+}
+
+// SetPWMCmd []bytes for set_pwm, `duty` is given in percent
+func SetPWMCmd(uid Address, number byte, duty byte) ([]byte, error) {
+	if duty > 100 {
+		return nil, fmt.Errorf("tinymesh:command: pwm duty cycle must be 0-100, got %v", duty)
+	}
+
+	return Command{UID: uid, Number: number, Cmd: CmdSetPWM, P1: duty}.Encode()
+}
+
+// InitConfigCmd []bytes for init_config, puts node `uid` in configuration mode
+func InitConfigCmd(uid Address, number byte) ([]byte, error) {
+	return Command{UID: uid, Number: number, Cmd: CmdInitConfig}.Encode()
+}
+
+// RouterResetCmd []bytes for router_reset
+func RouterResetCmd(uid Address, number byte) ([]byte, error) {
+	return Command{UID: uid, Number: number, Cmd: CmdRouterReset}.Encode()
+}
+
+// GetStatusCmd []bytes for get_status
+func GetStatusCmd(uid Address, number byte) ([]byte, error) {
+	return Command{UID: uid, Number: number, Cmd: CmdGetStatus}.Encode()
+}
+
+// GetDIDStatusCmd []bytes for get_did_status
+func GetDIDStatusCmd(uid Address, number byte) ([]byte, error) {
+	return Command{UID: uid, Number: number, Cmd: CmdGetDIDStatus}.Encode()
+}
+
+// GetConfigCmd []bytes for get_config
+func GetConfigCmd(uid Address, number byte) ([]byte, error) {
+	return Command{UID: uid, Number: number, Cmd: CmdGetConfig}.Encode()
+}
+
+// GetCalibrationCmd []bytes for get_calibration
+func GetCalibrationCmd(uid Address, number byte) ([]byte, error) {
+	return Command{UID: uid, Number: number, Cmd: CmdGetCalibration}.Encode()
+}
+
+// GetNIDCmd []bytes for get_nid command
+func GetNIDCmd(addr Address) ([]byte, error) {
+	return Command{UID: addr, Cmd: CmdGetNID}.Encode()
+}
+
+// SetGwConfigModeCmd []bytes representation of init_gw_config_mode command
+func SetGwConfigModeCmd(addr Address) ([]byte, error) {
+	return Command{UID: addr, Cmd: CmdInitGwConfig}.Encode()
+}
@@ -0,0 +1,71 @@
+package guri
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testUID = Address{1, 2, 3, 4}
+
+func TestCommandEncoders(t *testing.T) {
+	encode := func(buf []byte, err error) []byte {
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		return buf
+	}
+
+	tests := []struct {
+		name string
+		buf  []byte
+		want []byte
+	}{
+		{"set_output", encode(SetOutputCmd(testUID, 9, 0x05, 0x02)), []byte{10, 1, 2, 3, 4, 9, PacketTypeCommand, CmdSetOutput, 0x05, 0x02}},
+		{"set_pwm", encode(SetPWMCmd(testUID, 9, 100)), []byte{10, 1, 2, 3, 4, 9, PacketTypeCommand, CmdSetPWM, 100, 0}},
+		{"init_config", encode(InitConfigCmd(testUID, 9)), []byte{10, 1, 2, 3, 4, 9, PacketTypeCommand, CmdInitConfig, 0, 0}},
+		{"router_reset", encode(RouterResetCmd(testUID, 9)), []byte{10, 1, 2, 3, 4, 9, PacketTypeCommand, CmdRouterReset, 0, 0}},
+		{"get_status", encode(GetStatusCmd(testUID, 9)), []byte{10, 1, 2, 3, 4, 9, PacketTypeCommand, CmdGetStatus, 0, 0}},
+		{"get_did_status", encode(GetDIDStatusCmd(testUID, 9)), []byte{10, 1, 2, 3, 4, 9, PacketTypeCommand, CmdGetDIDStatus, 0, 0}},
+		{"get_config", encode(GetConfigCmd(testUID, 9)), []byte{10, 1, 2, 3, 4, 9, PacketTypeCommand, CmdGetConfig, 0, 0}},
+		{"get_calibration", encode(GetCalibrationCmd(testUID, 9)), []byte{10, 1, 2, 3, 4, 9, PacketTypeCommand, CmdGetCalibration, 0, 0}},
+		{"get_nid", encode(GetNIDCmd(Address{0, 0, 0, 0})), []byte{10, 0, 0, 0, 0, 0, PacketTypeCommand, CmdGetNID, 0, 0}},
+		{"init_gw_config", encode(SetGwConfigModeCmd(Address{0, 0, 0, 0})), []byte{10, 0, 0, 0, 0, 0, PacketTypeCommand, CmdInitGwConfig, 0, 0}},
+		{"serial", encode(SerialCmd(testUID, 9, []byte("abc"))), []byte{10, 1, 2, 3, 4, 9, PacketTypeSerialCommand, 'a', 'b', 'c'}},
+		{"serial largest", encode(SerialCmd(testUID, 9, make([]byte, MaxSerialPayload)))[:7], []byte{7 + MaxSerialPayload, 1, 2, 3, 4, 9, PacketTypeSerialCommand}},
+	}
+
+	for _, test := range tests {
+		if !bytes.Equal(test.buf, test.want) {
+			t.Errorf("%v: encoded %v, expected %v", test.name, test.buf, test.want)
+		}
+	}
+}
+
+func TestCommandEncoderErrors(t *testing.T) {
+	short := Address{1, 2, 3}
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"short address", second(Command{UID: short, Cmd: CmdGetStatus}.Encode())},
+		{"get_nid short address", second(GetNIDCmd(short))},
+		{"init_gw_config short address", second(SetGwConfigModeCmd(nil))},
+		{"serial short address", second(SerialCmd(short, 0, []byte("abc")))},
+		{"serial empty", second(SerialCmd(testUID, 0, nil))},
+		{"serial too long", second(SerialCmd(testUID, 0, make([]byte, MaxSerialPayload+1)))},
+		{"set_output set and clear", second(SetOutputCmd(testUID, 0, 0x03, 0x02))},
+		{"set_pwm over 100", second(SetPWMCmd(testUID, 0, 101))},
+	}
+
+	for _, test := range tests {
+		if nil == test.err {
+			t.Errorf("%v: expected an error", test.name)
+		}
+	}
+}
+
+func second(_ []byte, err error) error {
+	return err
+}
@@ -0,0 +1,122 @@
+package guri
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config settings read from a JSON or YAML file, keyed by flag name. Keys may
+// use underscores instead of dashes (ie, log_level) and lists are accepted
+// where a flag takes a comma separated list
+type Config map[string]interface{}
+
+// LoadConfig read configuration from a JSON or YAML (.yml, .yaml) file
+func LoadConfig(path string) (Config, error) {
+	buf, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+
+	config := Config{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(buf, &config)
+	default:
+		// keep numbers as written, large integers would otherwise turn into
+		// floats
+		decoder := json.NewDecoder(bytes.NewReader(buf))
+		decoder.UseNumber()
+		err = decoder.Decode(&config)
+	}
+
+	if nil != err {
+		return nil, fmt.Errorf("config: failed to parse %v: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// Values settings as flag values keyed by flag name
+func (config Config) Values() (map[string]string, error) {
+	values := map[string]string{}
+
+	for key, value := range config {
+		name := strings.Replace(key, "_", "-", -1)
+
+		switch v := value.(type) {
+		case nil:
+			values[name] = ""
+
+		case []interface{}:
+			parts := make([]string, len(v))
+			for i, part := range v {
+				parts[i] = fmt.Sprint(part)
+			}
+
+			values[name] = strings.Join(parts, ",")
+
+		case map[string]interface{}, map[interface{}]interface{}:
+			return nil, fmt.Errorf("%v: nested settings are not supported", key)
+
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+
+	return values, nil
+}
+
+// UpstreamChanged true if `a` and `b` connect to different upstreams and the
+// upstream must be replaced to apply them. Every setting pickUpstream builds
+// the upstream from is compared
+func UpstreamChanged(a, b Flags) bool {
+	if a.Remote != b.Remote || a.TLS != b.TLS || a.Proxy != b.Proxy || a.Stdio != b.Stdio {
+		return true
+	} else if a.Listen != b.Listen || a.ListenPolicy != b.ListenPolicy {
+		return true
+	} else if a.FailoverAfter != b.FailoverAfter || a.FailbackInterval != b.FailbackInterval {
+		return true
+	} else if !reflect.DeepEqual(a.TLSOptions, b.TLSOptions) {
+		return true
+	} else if len(a.Fanout) != len(b.Fanout) {
+		return true
+	} else if len(a.Fanout) > 0 && a.Backoff != b.Backoff {
+		// fanout consumers reconnect with their own backoff
+		return true
+	}
+
+	for i := range a.Fanout {
+		if a.Fanout[i] != b.Fanout[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// KeepUpstream copy the upstream settings compared by UpstreamChanged from
+// `current` to `next`, used when the new upstream could not be connected
+func KeepUpstream(next *Flags, current Flags) {
+	next.Remote = current.Remote
+	next.TLS = current.TLS
+	next.TLSOptions = current.TLSOptions
+	next.Proxy = current.Proxy
+	next.Stdio = current.Stdio
+	next.Listen = current.Listen
+	next.ListenPolicy = current.ListenPolicy
+	next.FailoverAfter = current.FailoverAfter
+	next.FailbackInterval = current.FailbackInterval
+	next.Fanout = current.Fanout
+
+	if len(current.Fanout) > 0 {
+		next.Backoff = current.Backoff
+	}
+}
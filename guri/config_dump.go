@@ -0,0 +1,48 @@
+package guri
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// ConfigDump decoded configuration and calibration memory of a module
+type ConfigDump struct {
+	Configuration    *ConfigMemory      `json:"configuration"`
+	Calibration      *CalibrationMemory `json:"calibration"`
+	RawConfiguration HexBytes           `json:"raw_configuration"`
+	RawCalibration   HexBytes           `json:"raw_calibration"`
+}
+
+// DumpConfiguration read configuration and calibration memory from `remote`,
+// the module is put in configuration mode and left again before returning
+func DumpConfiguration(ctx context.Context, remote *SerialRemote) (dump *ConfigDump, err error) {
+	if err = WaitForTinyMeshConfig(ctx, remote); nil != err {
+		return nil, err
+	}
+
+	defer func() {
+		if exitErr := RunConfigCmd(ctx, remote, 'X', false); nil != exitErr && nil == err {
+			err = fmt.Errorf("serial:config: failed to exit configuration mode: %v", exitErr)
+		}
+	}()
+
+	cfg, err := ReadConfigMemory(ctx, remote)
+	if nil != err {
+		return nil, fmt.Errorf("serial:config: %v", err)
+	}
+
+	cal, err := ReadCalibrationMemory(ctx, remote)
+	if nil != err {
+		return nil, fmt.Errorf("serial:config: %v", err)
+	}
+
+	log.Printf("serial:config: read %v bytes configuration, %v bytes calibration\n", len(cfg.Bytes()), len(cal.Bytes()))
+
+	return &ConfigDump{
+		Configuration:    cfg,
+		Calibration:      cal,
+		RawConfiguration: cfg.Bytes(),
+		RawCalibration:   cal.Bytes(),
+	}, nil
+}
@@ -1,6 +1,7 @@
 package guri
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -36,22 +37,27 @@ func verifyIDs(remote Remote, flags Flags) error {
 		return fmt.Errorf("main:config: Device in config mode... you must exit manually")
 	}
 
-	_, _ = remote.Write(GetNIDCmd([]byte{0, 0, 0, 0}), -1)
+	cmd, err := GetNIDCmd([]byte{0, 0, 0, 0})
+	if nil != err {
+		return err
+	}
+
+	_, _ = remote.Write(cmd, -1)
 
 	select {
 	case nidEv := <-remote.Channel():
-		ev, err := decode(nidEv)
+		ev, err := DecodeEvent(nidEv)
 
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		if !flags.NID.Equal(ev.address) {
-			return fmt.Errorf("main:config: failed to verify Network ID (%v vs %v)", flags.NID.ToString(), ev.address.ToString())
-		} else if !flags.SID.Equal(ev.sid) {
-			return fmt.Errorf("main:config: failed to verify System ID (%v vs %v)", flags.SID.ToString(), ev.sid.ToString())
-		} else if !flags.UID.Equal(ev.uid) {
-			return fmt.Errorf("main:config: failed to verify Unique ID (%v vs %v)", flags.UID.ToString(), ev.uid.ToString())
+		if !flags.NID.Equal(ev.Address) {
+			return fmt.Errorf("main:config: failed to verify Network ID (%v vs %v)", flags.NID.ToString(), ev.Address.ToString())
+		} else if !flags.SID.Equal(ev.SID) {
+			return fmt.Errorf("main:config: failed to verify System ID (%v vs %v)", flags.SID.ToString(), ev.SID.ToString())
+		} else if !flags.UID.Equal(ev.UID) {
+			return fmt.Errorf("main:config: failed to verify Unique ID (%v vs %v)", flags.UID.ToString(), ev.UID.ToString())
 		}
 
 		break
@@ -63,13 +69,18 @@ func verifyIDs(remote Remote, flags Flags) error {
 	return nil
 }
 
-func configureGateway(remote Remote, flags Flags) error {
+func configureGateway(ctx context.Context, remote Remote, flags Flags) error {
 	configMode, err := remoteInConfigMode(remote)
 
 	if nil != err {
 		return err
 	} else if !configMode {
-		_, err = remote.Write(SetGwConfigModeCmd([]byte{0, 0, 0, 0}), -1)
+		var cmd []byte
+		if cmd, err = SetGwConfigModeCmd([]byte{0, 0, 0, 0}); nil != err {
+			return err
+		}
+
+		_, err = remote.Write(cmd, -1)
 
 		if nil != err {
 			return err
@@ -80,95 +91,71 @@ func configureGateway(remote Remote, flags Flags) error {
 		if nil != err {
 			return err
 		} else if !configMode {
-			if !WaitForConfig(remote) {
+			if !WaitForConfig(ctx, remote) {
 				log.Fatalf("main:config: failed to enter config mode")
 			}
 		}
 	}
 
-	if err = RunConfigCmd(remote, '0', false); err != nil {
+	if err = RunConfigCmd(ctx, remote, '0', false); err != nil {
 		log.Fatalf("main:config: failed to read configuration memory: %v", err)
 	}
 
-	cfg := <-remote.Channel()
+	cfg, err := ParseConfigMemory(<-remote.Channel())
+	if nil != err {
+		log.Fatalf("main:config: %v", err)
+	}
 
-	if err = RunConfigCmd(remote, 'r', false); err != nil {
+	if err = RunConfigCmd(ctx, remote, 'r', false); err != nil {
 		log.Fatalf("main:config: failed to read calibration memory: %v", err)
 	}
 
-	calibration := <-remote.Channel()
-
-	usingProtocol := cfg[3]
-	deviceType := cfg[14]
-	uid := cfg[45:49]
-	sid := cfg[49:53]
-	nid := calibration[23:27]
+	calibration, err := ParseCalibrationMemory(<-remote.Channel())
+	if nil != err {
+		log.Fatalf("main:config: %v", err)
+	}
 
 	log.Printf("main:config: protocol=%v deviceType=%v uid=%v sid=%v nid=%v",
-		usingProtocol,
-		deviceType,
-		AddressToString(uid),
-		AddressToString(sid),
-		AddressToString(nid))
+		cfg.ProtocolMode,
+		cfg.DeviceType,
+		cfg.UID.ToString(),
+		cfg.SID.ToString(),
+		calibration.NID.ToString())
 
-	if 1 != deviceType {
+	if 1 != cfg.DeviceType {
 		log.Println("main:config: ensure gateway operations")
-		if err = RunConfigCmd(remote, 'G', true); err != nil {
+		if err = RunConfigCmd(ctx, remote, 'G', true); err != nil {
 			log.Fatalf("main:config: failed to enable gateway mode: %v", err)
 		}
 	}
 
-	newCfg := []ConfigValue{}
-
-	if 0 != usingProtocol {
-		newCfg = append(newCfg, ConfigValue{3, 0})
-	}
-
-	if !flags.UID.Equal(uid) {
-		newCfg = append(newCfg, ConfigValue{45, flags.UID[0]})
-		newCfg = append(newCfg, ConfigValue{46, flags.UID[1]})
-		newCfg = append(newCfg, ConfigValue{47, flags.UID[2]})
-		newCfg = append(newCfg, ConfigValue{48, flags.UID[3]})
-	}
-
-	if !flags.SID.Equal(sid) {
-		newCfg = append(newCfg, ConfigValue{49, flags.SID[0]})
-		newCfg = append(newCfg, ConfigValue{50, flags.SID[1]})
-		newCfg = append(newCfg, ConfigValue{51, flags.SID[2]})
-		newCfg = append(newCfg, ConfigValue{52, flags.SID[3]})
-	}
+	newCfg := gatewayConfig(cfg, flags)
 
 	if len(newCfg) > 0 {
 		log.Println("main:config: set configuration")
-		if err = SetConfigurationMemory(remote, newCfg); err != nil {
+		if err = SetConfigurationMemory(ctx, remote, newCfg); err != nil {
 			log.Fatalf("main:config:failed to set configuration memory: %v\n :: %v\n", newCfg, err)
 		}
 	}
 
-	if !flags.NID.Equal(nid) {
-		setNID := []ConfigValue{
-			ConfigValue{23, flags.NID[0]},
-			ConfigValue{24, flags.NID[1]},
-			ConfigValue{25, flags.NID[2]},
-			ConfigValue{26, flags.NID[3]},
-		}
-
+	if setNID := gatewayCalibration(calibration, flags); len(setNID) > 0 {
 		log.Println("main:config: set calibration")
-		if err = SetCalibrationMemory(remote, setNID); err != nil {
+		if err = SetCalibrationMemory(ctx, remote, setNID); err != nil {
 			log.Fatalf("main:config:failed to set calibration memory: %v\n :: %v\n", setNID, err)
 		}
 	}
 
 	// log.Println("config-mode: EXIT")
-	if err = RunConfigCmd(remote, 'X', false); err != nil {
+	if err = RunConfigCmd(ctx, remote, 'X', false); err != nil {
 		log.Fatalf("main:config: failed to exit configuration mode: %v", err)
 	}
 
 	return nil
 }
 
-// WaitForConfig attempt to put `remote` in configuration mode
-func WaitForConfig(remote Remote) bool {
+// WaitForConfig wait for the configuration prompt of `remote`, false if
+// something else arrived or `ctx` was cancelled first
+func WaitForConfig(ctx context.Context, remote Remote) bool {
 	for {
 		select {
 		case prompt := <-remote.Channel():
@@ -178,6 +165,9 @@ func WaitForConfig(remote Remote) bool {
 
 			return true
 
+		case <-ctx.Done():
+			return false
+
 		case <-time.After(500 * time.Millisecond):
 			continue
 		}
@@ -0,0 +1,203 @@
+package guri
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// FailoverRemote prioritized list of upstreams; switches to the next one after
+// a number of failed connects and periodically probes the first one to fail
+// back to it
+type FailoverRemote struct {
+	uris             []string
+	dial             func(ctx context.Context, uri string) (Remote, error)
+	failoverAfter    int
+	failbackInterval time.Duration
+
+	lock      sync.Mutex
+	remote    Remote
+	active    int
+	failures  int
+	lastProbe time.Time
+	// probing while a failback probe is dialing the primary
+	probing bool
+	// closed between Close and the next successful Connect
+	closed bool
+	// ctx of the last Connect, probes give up when it is cancelled
+	ctx context.Context
+}
+
+// ConnectFailover connect to the first reachable of `uris` using `dial`
+func ConnectFailover(ctx context.Context, uris []string, dial func(ctx context.Context, uri string) (Remote, error), failoverAfter int, failbackInterval time.Duration) (*FailoverRemote, error) {
+	if 0 == len(uris) {
+		return nil, errors.New("failover: no upstreams given")
+	}
+
+	if failoverAfter < 1 {
+		failoverAfter = 1
+	}
+
+	remote := &FailoverRemote{
+		uris:             uris,
+		dial:             dial,
+		failoverAfter:    failoverAfter,
+		failbackInterval: failbackInterval,
+	}
+
+	var err error
+
+	// walk the list once, starting with the primary
+	for range uris {
+		remote.failures = failoverAfter - 1
+
+		if err = remote.Connect(ctx); nil == err {
+			return remote, nil
+		} else if nil != ctx.Err() {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+// Connect dial the active upstream, moving on to the next one when it has
+// failed too many times in a row. The lock is not held while dialing
+func (remote *FailoverRemote) Connect(ctx context.Context) error {
+	remote.lock.Lock()
+	active := remote.active
+	remote.lock.Unlock()
+
+	uri := remote.uris[active]
+	conn, err := remote.dial(ctx, uri)
+
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	if nil != err && nil != ctx.Err() {
+		// cancelled, not a failure of the upstream
+		return err
+	} else if nil != err {
+		remote.failures = remote.failures + 1
+
+		if remote.failures >= remote.failoverAfter && len(remote.uris) > 1 {
+			remote.active = (remote.active + 1) % len(remote.uris)
+			remote.failures = 0
+			log.Printf("failover: %v failed, switching to %v\n", uri, remote.uris[remote.active])
+		}
+
+		return err
+	}
+
+	remote.remote = conn
+	remote.active = active
+	remote.failures = 0
+	remote.lastProbe = time.Now()
+	remote.closed = false
+	remote.ctx = ctx
+
+	return nil
+}
+
+// probe start a failback to the primary upstream when it is due, the dial
+// runs in the background so reading from the active upstream goes on
+func (remote *FailoverRemote) probe() {
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	if 0 == remote.active || remote.probing || time.Since(remote.lastProbe) < remote.failbackInterval {
+		return
+	}
+
+	remote.lastProbe = time.Now()
+	remote.probing = true
+
+	go remote.failback(remote.ctx)
+}
+
+// failback dial the primary upstream and swap it in when it is reachable
+func (remote *FailoverRemote) failback(ctx context.Context) {
+	conn, err := remote.dial(ctx, remote.uris[0])
+
+	remote.lock.Lock()
+	remote.probing = false
+
+	if nil != err {
+		remote.lock.Unlock()
+		log.Printf("failover: primary %v still unavailable: %v\n", remote.uris[0], err)
+		return
+	} else if 0 == remote.active || remote.closed || nil != ctx.Err() {
+		// already back on the primary, or shutting down
+		remote.lock.Unlock()
+		conn.Close()
+		return
+	}
+
+	log.Printf("failover: primary %v available, failing back from %v\n", remote.uris[0], remote.uris[remote.active])
+
+	old := remote.remote
+	remote.remote = conn
+	remote.active = 0
+	remote.failures = 0
+	remote.lock.Unlock()
+
+	if nil != old {
+		old.Close()
+	}
+}
+
+func (remote *FailoverRemote) current() Remote {
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	return remote.remote
+}
+
+// Channel return the channel of the active upstream
+func (remote *FailoverRemote) Channel() chan []byte {
+	return remote.current().Channel()
+}
+
+// Close close the active upstream, a running probe is discarded
+func (remote *FailoverRemote) Close() error {
+	remote.lock.Lock()
+	remote.closed = true
+	conn := remote.remote
+	remote.lock.Unlock()
+
+	return conn.Close()
+}
+
+// Recv attempt to receive maximum amount of bytes within duration `t` from
+// the active upstream
+func (remote *FailoverRemote) Recv(t time.Duration) ([]byte, error) {
+	remote.probe()
+
+	for {
+		conn := remote.current()
+		buf, err := conn.Recv(t)
+
+		// a failback replaced the upstream while we were reading from it
+		if nil != err && conn != remote.current() {
+			continue
+		}
+
+		return buf, err
+	}
+}
+
+// Notify pass `event` on to the active upstream if it is a Notifier
+func (remote *FailoverRemote) Notify(event string) error {
+	if notifier, ok := remote.current().(Notifier); ok {
+		return notifier.Notify(event)
+	}
+
+	return nil
+}
+
+// Write write to the active upstream
+func (remote *FailoverRemote) Write(buf []byte, timeout time.Duration) (int, error) {
+	return remote.current().Write(buf, timeout)
+}
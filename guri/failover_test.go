@@ -0,0 +1,105 @@
+package guri
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailoverProbeDoesNotBlockRecv(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary, backup := &fakeRemote{}, &fakeRemote{}
+	release := make(chan struct{})
+	dials := 0
+
+	dial := func(ctx context.Context, uri string) (Remote, error) {
+		if "backup" == uri {
+			return backup, nil
+		}
+
+		// the first connect finds the primary down, the probe hangs until
+		// released
+		if dials = dials + 1; 1 == dials {
+			return nil, errors.New("primary down")
+		}
+
+		select {
+		case <-release:
+			return primary, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	remote, err := ConnectFailover(ctx, []string{"primary", "backup"}, dial, 1, 0)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if backup != remote.current() {
+		t.Fatal("expected to be failed over to the backup")
+	}
+
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if _, err := remote.Recv(time.Millisecond); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("reading took %v while the probe was dialing", elapsed)
+	}
+
+	close(release)
+	waitFor(t, "failback to the primary", func() bool { return primary == remote.current() })
+
+	backup.lock.Lock()
+	defer backup.lock.Unlock()
+
+	if !backup.closed {
+		t.Fatal("backup was not closed after failing back")
+	}
+}
+
+func TestFailoverProbeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	backup := &fakeRemote{}
+	dials := 0
+
+	dial := func(ctx context.Context, uri string) (Remote, error) {
+		if "backup" == uri {
+			return backup, nil
+		} else if dials = dials + 1; 1 == dials {
+			return nil, errors.New("primary down")
+		}
+
+		// the probe hangs until guri shuts down
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	remote, err := ConnectFailover(ctx, []string{"primary", "backup"}, dial, 1, 0)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	remote.Recv(time.Millisecond)
+	cancel()
+
+	waitFor(t, "the probe to give up", func() bool {
+		remote.lock.Lock()
+		defer remote.lock.Unlock()
+
+		return !remote.probing
+	})
+
+	if backup != remote.current() {
+		t.Fatal("failed back although the probe was cancelled")
+	}
+}
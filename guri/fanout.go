@@ -0,0 +1,197 @@
+package guri
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// frames queued per consumer before new frames are dropped
+const fanoutQueueSize = 256
+
+// FanoutRemote broadcast traffic to several remotes and merge what they send
+// back into a single stream. The first remote is the primary upstream and is
+// written synchronously, so the loop learns whether a frame was delivered.
+// Every other consumer has its own queue and supervisor so a slow or failing
+// consumer never blocks the others
+type FanoutRemote struct {
+	consumers []*fanoutConsumer
+	channel   chan []byte
+	cancel    context.CancelFunc
+	policy    BackoffPolicy
+
+	// err set when the primary gave up reconnecting
+	lock sync.Mutex
+	err  error
+}
+
+type fanoutConsumer struct {
+	name   string
+	remote Remote
+	queue  chan []byte
+	sup    *supervisor
+}
+
+// ConnectFanout combine the already connected `remotes`, `names` are used
+// for logging and `policy` decides how failed consumers are reconnected. The
+// consumers are stopped when `ctx` is cancelled or on Close
+func ConnectFanout(ctx context.Context, names []string, remotes []Remote, policy BackoffPolicy) *FanoutRemote {
+	fanout := &FanoutRemote{policy: policy}
+
+	for i, remote := range remotes {
+		fanout.consumers = append(fanout.consumers, &fanoutConsumer{
+			name:   names[i],
+			remote: remote,
+		})
+	}
+
+	fanout.start(ctx)
+
+	return fanout
+}
+
+func (fanout *FanoutRemote) start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	fanout.channel = make(chan []byte, 256)
+	fanout.cancel = cancel
+	fanout.fail(nil)
+
+	for i, consumer := range fanout.consumers {
+		consumer.sup = &supervisor{
+			name:      "fanout[" + consumer.name + "]",
+			remote:    consumer.remote,
+			timeout:   500 * time.Millisecond,
+			reconnect: true,
+			frames:    fanout.channel,
+			ctx:       ctx,
+			backoff:   NewBackoff(fanout.policy),
+			giveUp:    consumer.giveUp,
+		}
+
+		if 0 == i {
+			// the loop reconnects the whole fanout once the primary is gone
+			consumer.sup.giveUp = fanout.fail
+		}
+
+		consumer.sup.start()
+
+		if i > 0 {
+			consumer.queue = make(chan []byte, fanoutQueueSize)
+			go consumer.writer(ctx)
+		}
+	}
+}
+
+func (consumer *fanoutConsumer) writer(ctx context.Context) {
+	for {
+		select {
+		case buf := <-consumer.queue:
+			if _, err := consumer.sup.Write(buf); nil != err {
+				log.Printf("fanout[%v]:write: %v\n", consumer.name, err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (consumer *fanoutConsumer) giveUp(err error) {
+	log.Printf("fanout[%v]: %v, dropping its frames\n", consumer.name, err)
+}
+
+// fail record that the primary gave up, see Recv
+func (fanout *FanoutRemote) fail(err error) {
+	fanout.lock.Lock()
+	defer fanout.lock.Unlock()
+
+	fanout.err = err
+}
+
+func (fanout *FanoutRemote) failed() error {
+	fanout.lock.Lock()
+	defer fanout.lock.Unlock()
+
+	return fanout.err
+}
+
+// Channel return the merged channel
+func (fanout *FanoutRemote) Channel() chan []byte {
+	return fanout.channel
+}
+
+// Close stop all consumers and close their remotes
+func (fanout *FanoutRemote) Close() error {
+	fanout.cancel()
+
+	for _, consumer := range fanout.consumers {
+		consumer.sup.Close()
+	}
+
+	return nil
+}
+
+// Connect reconnect all consumers after Close
+func (fanout *FanoutRemote) Connect(ctx context.Context) error {
+	for _, consumer := range fanout.consumers {
+		if err := consumer.remote.Connect(ctx); nil != err {
+			return err
+		}
+	}
+
+	fanout.start(ctx)
+
+	return nil
+}
+
+// Recv attempt to receive a frame from any consumer within duration `t`,
+// returns errReconnecting while the primary is down so the loop queues frames
+// meanwhile, and the error of the primary once it gave up reconnecting
+func (fanout *FanoutRemote) Recv(t time.Duration) ([]byte, error) {
+	if err := fanout.failed(); nil != err {
+		return nil, err
+	}
+
+	buf := []byte("")
+
+	select {
+	case buf = <-fanout.channel:
+	case <-time.After(t):
+	}
+
+	if !fanout.consumers[0].sup.Up() {
+		return buf, errReconnecting
+	}
+
+	return buf, nil
+}
+
+// Notify pass `event` on to every consumer that is a Notifier
+func (fanout *FanoutRemote) Notify(event string) error {
+	for _, consumer := range fanout.consumers {
+		if notifier, ok := consumer.remote.(Notifier); ok {
+			if err := notifier.Notify(event); nil != err {
+				log.Printf("fanout[%v]:notify: %v\n", consumer.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Write write `buf` to the primary and queue it for every other consumer,
+// consumers with a full queue miss it. Returns the result of the primary
+// write
+func (fanout *FanoutRemote) Write(buf []byte, timeout time.Duration) (int, error) {
+	for _, consumer := range fanout.consumers[1:] {
+		select {
+		case consumer.queue <- buf:
+		default:
+			log.Printf("fanout[%v]:write: queue full, dropping %v bytes\n", consumer.name, len(buf))
+		}
+	}
+
+	return fanout.consumers[0].sup.Write(buf)
+}
@@ -0,0 +1,191 @@
+package guri
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRemote in-memory remote, `fail` drops the connection and refuses to
+// reconnect until `recover` is called
+type fakeRemote struct {
+	lock    sync.Mutex
+	closed  bool
+	refuse  bool
+	written [][]byte
+}
+
+var errFakeClosed = errors.New("fake: closed")
+
+func (remote *fakeRemote) Channel() chan []byte {
+	return nil
+}
+
+func (remote *fakeRemote) Close() error {
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	remote.closed = true
+	return nil
+}
+
+func (remote *fakeRemote) Connect(ctx context.Context) error {
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	if remote.refuse {
+		return errFakeClosed
+	}
+
+	remote.closed = false
+	return nil
+}
+
+func (remote *fakeRemote) Recv(t time.Duration) ([]byte, error) {
+	time.Sleep(t)
+
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	if remote.closed {
+		return nil, errFakeClosed
+	}
+
+	return []byte(""), nil
+}
+
+func (remote *fakeRemote) Write(buf []byte, timeout time.Duration) (int, error) {
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	if remote.closed {
+		return 0, errFakeClosed
+	}
+
+	remote.written = append(remote.written, buf)
+	return len(buf), nil
+}
+
+func (remote *fakeRemote) fail() {
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	remote.closed = true
+	remote.refuse = true
+}
+
+func (remote *fakeRemote) recover() {
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	remote.refuse = false
+}
+
+func (remote *fakeRemote) frames() int {
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	return len(remote.written)
+}
+
+// waitFor poll `cond` for up to a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+var testFanoutPolicy = BackoffPolicy{Initial: 10 * time.Millisecond, Multiplier: 1, Max: 10 * time.Millisecond}
+
+func TestFanoutWriteReportsPrimary(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary, secondary := &fakeRemote{}, &fakeRemote{}
+	fanout := ConnectFanout(ctx, []string{"primary", "secondary"}, []Remote{primary, secondary}, testFanoutPolicy)
+	defer fanout.Close()
+
+	if _, err := fanout.Write([]byte{1}, -1); nil != err {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "secondary write", func() bool { return 1 == secondary.frames() })
+
+	if 1 != primary.frames() {
+		t.Fatalf("primary got %v frames, expected 1", primary.frames())
+	}
+
+	primary.fail()
+	waitFor(t, "primary down", func() bool { return !fanout.consumers[0].sup.Up() })
+
+	if _, err := fanout.Write([]byte{2}, -1); nil == err {
+		t.Fatal("write succeeded while the primary is down")
+	}
+
+	// the other consumers are not affected
+	waitFor(t, "secondary write", func() bool { return 2 == secondary.frames() })
+
+	primary.recover()
+	waitFor(t, "primary up", func() bool { return fanout.consumers[0].sup.Up() })
+
+	if _, err := fanout.Write([]byte{3}, -1); nil != err {
+		t.Fatal(err)
+	}
+}
+
+// the loop sees the primary going down and coming back through the supervisor
+// wrapping the fanout, without the fanout being reconnected
+func TestFanoutReportsPrimaryState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary, secondary := &fakeRemote{}, &fakeRemote{}
+	fanout := ConnectFanout(ctx, []string{"primary", "secondary"}, []Remote{primary, secondary}, testFanoutPolicy)
+
+	upstream := &supervisor{
+		name:      "upstream",
+		remote:    fanout,
+		timeout:   10 * time.Millisecond,
+		reconnect: true,
+		frames:    make(chan []byte, 1),
+		state:     make(chan bool),
+		ctx:       ctx,
+		backoff:   NewBackoff(testFanoutPolicy),
+	}
+
+	upstream.start()
+	defer fanout.Close()
+
+	for _, step := range []struct {
+		change func()
+		up     bool
+	}{
+		{primary.fail, false},
+		{primary.recover, true},
+	} {
+		step.change()
+
+		select {
+		case up := <-upstream.state:
+			if step.up != up || step.up != upstream.Up() {
+				t.Fatalf("state %v (up %v), expected %v", up, upstream.Up(), step.up)
+			}
+
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for state %v", step.up)
+		}
+	}
+
+	secondary.lock.Lock()
+	defer secondary.lock.Unlock()
+
+	if secondary.closed {
+		t.Fatal("the fanout was torn down to reconnect the primary")
+	}
+}
@@ -0,0 +1,76 @@
+package guri
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// FileRemote write-only remote appending a line per frame to a log file
+type FileRemote struct {
+	path string
+	file *os.File
+}
+
+// ConnectFile open `path` for appending
+func ConnectFile(path string) (*FileRemote, error) {
+	remote := &FileRemote{
+		path: path,
+	}
+
+	if err := remote.Connect(context.Background()); nil != err {
+		return nil, err
+	}
+
+	return remote, nil
+}
+
+// Connect open the log file
+func (remote *FileRemote) Connect(ctx context.Context) error {
+	log.Printf("file:open uri=%v\n", remote.path)
+
+	file, err := os.OpenFile(remote.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if nil != err {
+		return err
+	}
+
+	remote.file = file
+
+	return nil
+}
+
+// Channel files never produce data
+func (remote *FileRemote) Channel() chan []byte {
+	return nil
+}
+
+// Close close the log file
+func (remote *FileRemote) Close() error {
+	return remote.file.Close()
+}
+
+// Recv files never produce data, waits for `t` and returns nothing
+func (remote *FileRemote) Recv(t time.Duration) ([]byte, error) {
+	time.Sleep(t)
+	return []byte(""), nil
+}
+
+// Notify append `event` as a timestamped line
+func (remote *FileRemote) Notify(event string) error {
+	_, err := fmt.Fprintf(remote.file, "%v event %v\n", time.Now().UTC().Format(time.RFC3339Nano), event)
+	return err
+}
+
+// Write append `buf` as a timestamped line of hexadecimal bytes
+func (remote *FileRemote) Write(buf []byte, timeout time.Duration) (int, error) {
+	_, err := fmt.Fprintf(remote.file, "%v [%v] %x\n", time.Now().UTC().Format(time.RFC3339Nano), len(buf), buf)
+
+	if nil != err {
+		return 0, err
+	}
+
+	return len(buf), nil
+}
@@ -0,0 +1,135 @@
+package guri
+
+import (
+	"log"
+)
+
+// MinPacketLength smallest valid Tinymesh packet (a serial command carrying a
+// single byte)
+const MinPacketLength = 8
+
+// MaxPacketLength largest valid Tinymesh packet (serial data with full payload)
+const MaxPacketLength = 138
+
+// AckByte a lone ASCII ACK the upstream may send ahead of a packet. It is too
+// short to be a length byte and is passed on as a frame of its own, so it
+// reaches the module in a separate write
+const AckByte = 0x06
+
+// Framer reassembles Tinymesh packets from a byte stream using the length
+// byte found at offset 0 of every packet. A candidate packet is only accepted
+// when its packet type matches its length, so a stray byte that happens to be
+// a valid length does not swallow the packets that follow
+type Framer struct {
+	name string
+	buf  []byte
+}
+
+// NewFramer create a framer, `name` is used for logging
+func NewFramer(name string) *Framer {
+	return &Framer{
+		name: name,
+		buf:  make([]byte, 0, 2*MaxPacketLength),
+	}
+}
+
+// Push append `data` to the stream and return all complete packets
+func (framer *Framer) Push(data []byte) [][]byte {
+	var frames [][]byte
+
+	framer.buf = append(framer.buf, data...)
+
+	for len(framer.buf) > 0 {
+		length := int(framer.buf[0])
+
+		if AckByte == framer.buf[0] {
+			frames = append(frames, []byte{AckByte})
+			framer.buf = framer.buf[1:]
+			continue
+		}
+
+		if length < MinPacketLength || length > MaxPacketLength {
+			// not the start of a packet, skip a byte and try to resync
+			log.Printf("%v:framer: discarding byte %v, invalid packet length\n", framer.name, framer.buf[0])
+			framer.buf = framer.buf[1:]
+			continue
+		}
+
+		ok, more := plausible(framer.buf, length)
+
+		if more {
+			break
+		} else if !ok {
+			log.Printf("%v:framer: discarding byte %v, no packet of that length\n", framer.name, framer.buf[0])
+			framer.buf = framer.buf[1:]
+			continue
+		}
+
+		if len(framer.buf) < length {
+			break
+		}
+
+		frame := make([]byte, length)
+		copy(frame, framer.buf[:length])
+		frames = append(frames, frame)
+
+		framer.buf = framer.buf[length:]
+	}
+
+	// move remaining bytes to the front so the buffer does not grow forever
+	framer.buf = append(framer.buf[:0:0], framer.buf...)
+
+	return frames
+}
+
+// plausible whether `buf` can start a packet of `length` bytes, judged by the
+// packet type at offset 6 (commands) or 16 (packets from a gateway). `more` is
+// true while the packet type has not arrived yet
+func plausible(buf []byte, length int) (ok bool, more bool) {
+	if len(buf) <= 6 {
+		return false, true
+	}
+
+	switch buf[6] {
+	case PacketTypeCommand:
+		if 10 == length {
+			return true, false
+		}
+
+	case PacketTypeSerialCommand:
+		if length <= 7+MaxSerialPayload {
+			return true, false
+		}
+	}
+
+	if length <= 16 {
+		return false, false
+	} else if len(buf) <= 16 {
+		return false, true
+	}
+
+	switch buf[16] {
+	case PacketTypeEvent:
+		return 35 == length, false
+
+	case PacketTypeSerial:
+		return length >= 19, false
+	}
+
+	return false, false
+}
+
+// Pending number of bytes waiting for the rest of a packet
+func (framer *Framer) Pending() int {
+	return len(framer.buf)
+}
+
+// Flush discard any incomplete packet, used when the stream has been idle for
+// longer than a packet could take to arrive
+func (framer *Framer) Flush() {
+	if len(framer.buf) > 0 {
+		log.Printf("%v:framer: discarding incomplete packet %v\n", framer.name, framer.buf)
+	}
+
+	framer.buf = framer.buf[:0]
+}
@@ -0,0 +1,129 @@
+package guri
+
+import (
+	"bytes"
+	"testing"
+)
+
+// packetOf serial data from a gateway, or a serial command for lengths too
+// short for that
+func packetOf(length int) []byte {
+	if length >= 19 {
+		return testSerialData(bytes.Repeat([]byte{0xaa}, length-18))
+	}
+
+	buf, _ := SerialCmd(Address{1, 2, 3, 4}, 1, bytes.Repeat([]byte{0xaa}, length-7))
+	return buf
+}
+
+func join(bufs ...[]byte) []byte {
+	return bytes.Join(bufs, nil)
+}
+
+func TestFramerPush(t *testing.T) {
+	small := packetOf(MinPacketLength)
+	event := packetOf(35)
+	large := packetOf(MaxPacketLength)
+
+	tests := []struct {
+		name   string
+		pushes [][]byte
+		frames [][]byte
+		rest   int
+	}{
+		{"single", [][]byte{event}, [][]byte{event}, 0},
+		{"smallest", [][]byte{small}, [][]byte{small}, 0},
+		{"largest", [][]byte{large}, [][]byte{large}, 0},
+		{"split", [][]byte{event[:3], event[3:20], event[20:]}, [][]byte{event}, 0},
+		{"split length byte", [][]byte{event[:1], event[1:]}, [][]byte{event}, 0},
+		{"coalesced", [][]byte{join(event, small, large)}, [][]byte{event, small, large}, 0},
+		{"coalesced and split", [][]byte{join(event, small[:4]), small[4:]}, [][]byte{event, small}, 0},
+		{"incomplete", [][]byte{event[:10]}, nil, 10},
+		{"resync leading garbage", [][]byte{join([]byte{0, 1, 2, 255}, event)}, [][]byte{event}, 0},
+		{"resync between packets", [][]byte{join(event, []byte{MaxPacketLength + 1}, small)}, [][]byte{event, small}, 0},
+		{"resync stray length byte", [][]byte{join(event, []byte{50}, small, event)}, [][]byte{event, small, event}, 0},
+		{"resync stray length byte split", [][]byte{join(event, []byte{50}, small[:3]), small[3:], event}, [][]byte{event, small, event}, 0},
+		{"ack byte", [][]byte{join([]byte{AckByte}, event)}, [][]byte{{AckByte}, event}, 0},
+	}
+
+	for _, test := range tests {
+		framer := NewFramer("test")
+
+		var frames [][]byte
+		for _, buf := range test.pushes {
+			frames = append(frames, framer.Push(buf)...)
+		}
+
+		if len(frames) != len(test.frames) {
+			t.Errorf("%v: got %v frames, expected %v", test.name, len(frames), len(test.frames))
+			continue
+		}
+
+		for i := range frames {
+			if !bytes.Equal(frames[i], test.frames[i]) {
+				t.Errorf("%v: frame %v is %v, expected %v", test.name, i, frames[i], test.frames[i])
+			}
+		}
+
+		if framer.Pending() != test.rest {
+			t.Errorf("%v: %v bytes pending, expected %v", test.name, framer.Pending(), test.rest)
+		}
+	}
+}
+
+func TestFramerFlush(t *testing.T) {
+	framer := NewFramer("test")
+	event := packetOf(35)
+
+	framer.Push(event[:10])
+	framer.Flush()
+
+	if 0 != framer.Pending() {
+		t.Fatalf("%v bytes pending after flush", framer.Pending())
+	}
+
+	if frames := framer.Push(event); 1 != len(frames) || !bytes.Equal(frames[0], event) {
+		t.Fatalf("got %v after flush, expected %v", frames, event)
+	}
+}
+
+// every packet the command encoder can produce must make it through the framer
+func TestFramerEncoderOutput(t *testing.T) {
+	uid := Address{1, 2, 3, 4}
+
+	var packets [][]byte
+
+	for n := 1; n <= MaxSerialPayload; n++ {
+		buf, err := SerialCmd(uid, byte(n), bytes.Repeat([]byte{0xaa}, n))
+		if nil != err {
+			t.Fatalf("serial command of %v bytes: %v", n, err)
+		}
+
+		packets = append(packets, buf)
+	}
+
+	for _, encode := range []func(Address, byte) ([]byte, error){InitConfigCmd, RouterResetCmd, GetStatusCmd, GetDIDStatusCmd, GetConfigCmd, GetCalibrationCmd} {
+		buf, err := encode(uid, 1)
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		packets = append(packets, buf)
+	}
+
+	framer := NewFramer("test")
+
+	for _, packet := range packets {
+		frames := framer.Push(packet)
+
+		if 1 != len(frames) || !bytes.Equal(frames[0], packet) {
+			t.Errorf("packet %v framed as %v", packet, frames)
+		}
+	}
+
+	// and when they arrive in one read
+	frames := framer.Push(join(packets...))
+	if len(frames) != len(packets) {
+		t.Errorf("got %v frames from a single read, expected %v", len(frames), len(packets))
+	}
+}
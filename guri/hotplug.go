@@ -0,0 +1,140 @@
+package guri
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Notifier remotes that want to be told about events on the serial side, ie.
+// the serialport being unplugged
+type Notifier interface {
+	Notify(event string) error
+}
+
+// HotplugEvent the serialport appeared or disappeared
+type HotplugEvent struct {
+	URI     string
+	Path    string
+	Present bool
+}
+
+func (ev HotplugEvent) String() string {
+	if ev.Present {
+		return fmt.Sprintf("serial %v connected (%v)", ev.URI, ev.Path)
+	}
+
+	return fmt.Sprintf("serial %v disconnected", ev.URI)
+}
+
+// HotplugWatcher polls for the serialport appearing and disappearing
+type HotplugWatcher struct {
+	uri      string
+	interval time.Duration
+	events   chan HotplugEvent
+	present  int32
+}
+
+// WatchHotplug watch the serialport `uri`, a path or selector, every
+// `interval` until `ctx` is cancelled
+func WatchHotplug(ctx context.Context, uri string, interval time.Duration) *HotplugWatcher {
+	watcher := &HotplugWatcher{
+		uri:      uri,
+		interval: interval,
+		events:   make(chan HotplugEvent, 16),
+	}
+
+	if _, ok := watcher.lookup(); ok {
+		watcher.present = 1
+	}
+
+	go watcher.run(ctx)
+
+	return watcher
+}
+
+// lookup path of the serialport if it is plugged in
+func (watcher *HotplugWatcher) lookup() (string, bool) {
+	path, err := ResolvePort(watcher.uri)
+	if nil != err {
+		return "", false
+	}
+
+	if ports, err := PortList(); nil == err {
+		for _, port := range ports {
+			if port.Name == path {
+				return path, true
+			}
+		}
+	}
+
+	// symlinks like /dev/serial/by-id/... are not part of the port list
+	if _, err = os.Stat(path); nil == err {
+		return path, true
+	}
+
+	return "", false
+}
+
+func (watcher *HotplugWatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(watcher.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		path, present := watcher.lookup()
+
+		if present == watcher.Present() {
+			continue
+		}
+
+		if present {
+			atomic.StoreInt32(&watcher.present, 1)
+		} else {
+			atomic.StoreInt32(&watcher.present, 0)
+		}
+
+		ev := HotplugEvent{URI: watcher.uri, Path: path, Present: present}
+		log.Printf("hotplug: %v\n", ev)
+
+		select {
+		case watcher.events <- ev:
+		default:
+			log.Printf("hotplug: event queue full, dropping %v\n", ev)
+		}
+	}
+}
+
+// Events channel receiving every change
+func (watcher *HotplugWatcher) Events() <-chan HotplugEvent {
+	return watcher.events
+}
+
+// Present true while the serialport is plugged in
+func (watcher *HotplugWatcher) Present() bool {
+	return 1 == atomic.LoadInt32(&watcher.present)
+}
+
+// WaitPresent wait until the serialport is plugged in
+func (watcher *HotplugWatcher) WaitPresent(ctx context.Context) error {
+	ticker := time.NewTicker(watcher.interval)
+	defer ticker.Stop()
+
+	for !watcher.Present() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,347 @@
+package guri
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JournalOptions settings for the on-disk journal
+type JournalOptions struct {
+	Dir string
+	// SegmentBytes size at which a new segment file is started
+	SegmentBytes int64
+	// Sync fsync every append and ack
+	Sync bool
+}
+
+// Journal crash-safe, segment based log of frames waiting for the upstream.
+// Frames are appended before they are forwarded and acknowledged once the
+// upstream write succeeded; segments with only acknowledged frames are removed
+type Journal struct {
+	opts JournalOptions
+
+	// first sequence number of every segment, oldest first
+	segments []uint64
+	writer   *os.File
+	size     int64
+
+	ackFile *os.File
+	acked   uint64
+	next    uint64
+}
+
+// length, crc32 and sequence number prefixing every record
+const journalHeaderSize = 16
+
+const journalSegmentExt = ".seg"
+
+// OpenJournal open, or create, the journal in `opts.Dir`, recovering from torn
+// writes left by a crash
+func OpenJournal(opts JournalOptions) (*Journal, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 1024 * 1024
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0700); nil != err {
+		return nil, err
+	}
+
+	journal := &Journal{opts: opts, next: 1}
+
+	ackFile, err := os.OpenFile(filepath.Join(opts.Dir, "ack"), os.O_CREATE|os.O_RDWR, 0600)
+	if nil != err {
+		return nil, err
+	}
+
+	journal.ackFile = ackFile
+
+	buf := make([]byte, 8)
+	if n, _ := ackFile.ReadAt(buf, 0); 8 == n {
+		journal.acked = binary.BigEndian.Uint64(buf)
+	}
+
+	if err = journal.recover(); nil != err {
+		ackFile.Close()
+		return nil, err
+	}
+
+	if journal.next <= journal.acked {
+		journal.next = journal.acked + 1
+	}
+
+	log.Printf("journal:open dir=%v pending=%v\n", opts.Dir, journal.Pending())
+
+	return journal, nil
+}
+
+// recover find the segments and the next sequence number, truncating the last
+// segment after its last intact record
+func (journal *Journal) recover() error {
+	files, err := ioutil.ReadDir(journal.opts.Dir)
+	if nil != err {
+		return err
+	}
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), journalSegmentExt) {
+			continue
+		}
+
+		first, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), journalSegmentExt), 10, 64)
+		if nil != err {
+			continue
+		}
+
+		journal.segments = append(journal.segments, first)
+	}
+
+	sort.Slice(journal.segments, func(i, j int) bool { return journal.segments[i] < journal.segments[j] })
+
+	if 0 == len(journal.segments) {
+		return nil
+	}
+
+	last := journal.segments[len(journal.segments)-1]
+	path := journal.segmentPath(last)
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if nil != err {
+		return err
+	}
+
+	valid := int64(0)
+	journal.next = last
+
+	err = readRecords(file, func(seq uint64, buf []byte, end int64) error {
+		journal.next = seq + 1
+		valid = end
+		return nil
+	})
+
+	if nil != err {
+		log.Printf("journal:recover: %v, truncating %v at %v\n", err, path, valid)
+	}
+
+	if err = file.Truncate(valid); nil != err {
+		file.Close()
+		return err
+	}
+
+	if _, err = file.Seek(valid, io.SeekStart); nil != err {
+		file.Close()
+		return err
+	}
+
+	journal.writer = file
+	journal.size = valid
+
+	return nil
+}
+
+// readRecords call `fn` for every intact record in `file`, `end` is the offset
+// just past the record. Returns nil at a clean end of file
+func readRecords(file *os.File, fn func(seq uint64, buf []byte, end int64) error) error {
+	offset := int64(0)
+	header := make([]byte, journalHeaderSize)
+
+	for {
+		if _, err := file.ReadAt(header, offset); io.EOF == err {
+			return nil
+		} else if nil != err {
+			return err
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		sum := binary.BigEndian.Uint32(header[4:8])
+		seq := binary.BigEndian.Uint64(header[8:16])
+
+		if length > MaxPacketLength*16 {
+			return fmt.Errorf("record at %v: invalid length %v", offset, length)
+		}
+
+		buf := make([]byte, length)
+		if _, err := file.ReadAt(buf, offset+journalHeaderSize); nil != err {
+			return fmt.Errorf("record at %v: %v", offset, err)
+		}
+
+		if sum != crc32.ChecksumIEEE(append(header[8:16:16], buf...)) {
+			return fmt.Errorf("record at %v: checksum mismatch", offset)
+		}
+
+		offset = offset + journalHeaderSize + int64(length)
+
+		if err := fn(seq, buf, offset); nil != err {
+			return err
+		}
+	}
+}
+
+func (journal *Journal) segmentPath(first uint64) string {
+	return filepath.Join(journal.opts.Dir, fmt.Sprintf("%020d%v", first, journalSegmentExt))
+}
+
+// Pending number of frames appended but not yet acknowledged
+func (journal *Journal) Pending() uint64 {
+	return journal.next - 1 - journal.acked
+}
+
+// Append write `buf` to the journal, returns its sequence number
+func (journal *Journal) Append(buf []byte) (uint64, error) {
+	if nil == journal.writer || journal.size >= journal.opts.SegmentBytes {
+		if err := journal.rotate(); nil != err {
+			return 0, err
+		}
+	}
+
+	seq := journal.next
+
+	record := make([]byte, journalHeaderSize, journalHeaderSize+len(buf))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(buf)))
+	binary.BigEndian.PutUint64(record[8:16], seq)
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(append(record[8:16:16], buf...)))
+	record = append(record, buf...)
+
+	if _, err := journal.writer.Write(record); nil != err {
+		return 0, err
+	}
+
+	if journal.opts.Sync {
+		if err := journal.writer.Sync(); nil != err {
+			return 0, err
+		}
+	}
+
+	journal.size = journal.size + int64(len(record))
+	journal.next = seq + 1
+
+	return seq, nil
+}
+
+func (journal *Journal) rotate() error {
+	if nil != journal.writer {
+		journal.writer.Close()
+	}
+
+	file, err := os.OpenFile(journal.segmentPath(journal.next), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if nil != err {
+		journal.writer = nil
+		return err
+	}
+
+	journal.writer = file
+	journal.size = 0
+	journal.segments = append(journal.segments, journal.next)
+
+	return nil
+}
+
+// Ack mark every frame up to and including `seq` as delivered, removing
+// segments that hold nothing but delivered frames
+func (journal *Journal) Ack(seq uint64) error {
+	if err := journal.ack(seq); nil != err {
+		return err
+	}
+
+	return journal.prune()
+}
+
+// ack record `seq` as delivered without touching the segments
+func (journal *Journal) ack(seq uint64) error {
+	if seq <= journal.acked {
+		return nil
+	}
+
+	journal.acked = seq
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+
+	if _, err := journal.ackFile.WriteAt(buf, 0); nil != err {
+		return err
+	}
+
+	if journal.opts.Sync {
+		if err := journal.ackFile.Sync(); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prune remove segments that hold nothing but delivered frames, none of them
+// may be open as Windows can not remove open files
+func (journal *Journal) prune() error {
+	// the active segment is never removed
+	for len(journal.segments) > 1 && journal.segments[1]-1 <= journal.acked {
+		if err := os.Remove(journal.segmentPath(journal.segments[0])); nil != err && !os.IsNotExist(err) {
+			return err
+		}
+
+		journal.segments = journal.segments[1:]
+	}
+
+	return nil
+}
+
+// Replay call `fn` for every pending frame, oldest first, and acknowledge it
+// when `fn` succeeds. Stops at the first error
+func (journal *Journal) Replay(fn func(buf []byte) error) error {
+	errStop := errors.New("stop")
+	var fnErr error
+
+	for _, first := range append([]uint64{}, journal.segments...) {
+		file, err := os.Open(journal.segmentPath(first))
+		if os.IsNotExist(err) {
+			continue
+		} else if nil != err {
+			return err
+		}
+
+		err = readRecords(file, func(seq uint64, buf []byte, end int64) error {
+			if seq <= journal.acked {
+				return nil
+			}
+
+			if fnErr = fn(buf); nil != fnErr {
+				return errStop
+			}
+
+			return journal.ack(seq)
+		})
+
+		// segments are only removed once closed
+		file.Close()
+
+		if perr := journal.prune(); nil != perr && nil == err {
+			err = perr
+		}
+
+		if errStop == err {
+			return fnErr
+		} else if nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close close the journal files
+func (journal *Journal) Close() error {
+	if nil != journal.writer {
+		journal.writer.Close()
+	}
+
+	return journal.ackFile.Close()
+}
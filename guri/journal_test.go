@@ -0,0 +1,184 @@
+package guri
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestJournal(t *testing.T, dir string, segmentBytes int64) *Journal {
+	journal, err := OpenJournal(JournalOptions{Dir: dir, SegmentBytes: segmentBytes})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	return journal
+}
+
+func replayAll(t *testing.T, journal *Journal) []byte {
+	var got []byte
+
+	err := journal.Replay(func(buf []byte) error {
+		got = append(got, buf[0])
+		return nil
+	})
+
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+journalSegmentExt))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestJournalReopen(t *testing.T) {
+	dir := t.TempDir()
+	journal := openTestJournal(t, dir, 0)
+
+	for n := byte(1); n <= 3; n++ {
+		if _, err := journal.Append([]byte{n}); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	if err := journal.Ack(1); nil != err {
+		t.Fatal(err)
+	}
+
+	journal.Close()
+
+	journal = openTestJournal(t, dir, 0)
+	defer journal.Close()
+
+	if 2 != journal.Pending() {
+		t.Fatalf("%v frames pending after reopen, expected 2", journal.Pending())
+	}
+
+	if got := replayAll(t, journal); string(got) != string([]byte{2, 3}) {
+		t.Fatalf("replayed %v, expected [2 3]", got)
+	}
+
+	if seq, _ := journal.Append([]byte{4}); 4 != seq {
+		t.Fatalf("appended as %v, expected 4", seq)
+	}
+}
+
+func TestJournalRecoverTornRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(path string, size int64) error
+	}{
+		{"truncated payload", func(path string, size int64) error {
+			return os.Truncate(path, size-1)
+		}},
+		{"truncated header", func(path string, size int64) error {
+			return os.Truncate(path, size-1-journalHeaderSize+4)
+		}},
+		{"checksum mismatch", func(path string, size int64) error {
+			file, err := os.OpenFile(path, os.O_RDWR, 0600)
+			if nil != err {
+				return err
+			}
+
+			defer file.Close()
+			_, err = file.WriteAt([]byte{0xff}, size-1)
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		journal := openTestJournal(t, dir, 0)
+
+		for n := byte(1); n <= 3; n++ {
+			journal.Append([]byte{n})
+		}
+
+		size := journal.size
+		journal.Close()
+
+		if err := test.corrupt(segmentFiles(t, dir)[0], size); nil != err {
+			t.Fatal(err)
+		}
+
+		journal = openTestJournal(t, dir, 0)
+
+		if 2 != journal.Pending() {
+			t.Errorf("%v: %v frames pending, expected 2", test.name, journal.Pending())
+		}
+
+		// the torn record is overwritten by the next append
+		if seq, err := journal.Append([]byte{4}); nil != err || 3 != seq {
+			t.Errorf("%v: appended as %v (%v), expected 3", test.name, seq, err)
+		}
+
+		if got := replayAll(t, journal); string(got) != string([]byte{1, 2, 4}) {
+			t.Errorf("%v: replayed %v, expected [1 2 4]", test.name, got)
+		}
+
+		journal.Close()
+	}
+}
+
+func TestJournalReplayStops(t *testing.T) {
+	journal := openTestJournal(t, t.TempDir(), 0)
+	defer journal.Close()
+
+	for n := byte(1); n <= 3; n++ {
+		journal.Append([]byte{n})
+	}
+
+	failed := errors.New("write failed")
+
+	err := journal.Replay(func(buf []byte) error {
+		if 2 == buf[0] {
+			return failed
+		}
+
+		return nil
+	})
+
+	if failed != err {
+		t.Fatalf("replay returned %v, expected the write error", err)
+	}
+
+	if got := replayAll(t, journal); string(got) != string([]byte{2, 3}) {
+		t.Fatalf("replayed %v after failure, expected [2 3]", got)
+	}
+}
+
+func TestJournalRemovesDeliveredSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	// every record gets a segment of its own
+	journal := openTestJournal(t, dir, 1)
+	defer journal.Close()
+
+	for n := byte(1); n <= 4; n++ {
+		journal.Append([]byte{n})
+	}
+
+	if 4 != len(segmentFiles(t, dir)) {
+		t.Fatalf("%v segments, expected 4", len(segmentFiles(t, dir)))
+	}
+
+	replayAll(t, journal)
+
+	// the active segment is kept
+	if files := segmentFiles(t, dir); 1 != len(files) {
+		t.Fatalf("%v left after replay, expected only the active segment", files)
+	}
+
+	if 0 != journal.Pending() {
+		t.Fatalf("%v frames pending after replay", journal.Pending())
+	}
+}
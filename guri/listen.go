@@ -0,0 +1,309 @@
+package guri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Write policies for ListenRemote, decides which clients may write to the
+// serial port
+const (
+	// WritePolicyAll every client may write
+	WritePolicyAll = "all"
+	// WritePolicyFirst only the longest connected client may write
+	WritePolicyFirst = "first"
+	// WritePolicyNone clients are read-only
+	WritePolicyNone = "none"
+)
+
+// time allowed for a client to accept a write before it is disconnected
+const listenWriteTimeout = 1 * time.Second
+
+// ListenRemote accept local clients on a TCP port or unix socket and relay
+// traffic to them
+type ListenRemote struct {
+	uri     string
+	network string
+	address string
+	policy  string
+	shared  *sharedListener
+	channel chan []byte
+
+	lock    sync.Mutex
+	clients []net.Conn
+}
+
+// ConnectListen start listening on `uri`, either host:port or unix:/path
+func ConnectListen(ctx context.Context, uri string, policy string) (*ListenRemote, error) {
+	remote := &ListenRemote{
+		uri:     uri,
+		network: "tcp",
+		address: uri,
+		policy:  policy,
+	}
+
+	if strings.HasPrefix(uri, "unix:") {
+		remote.network = "unix"
+		remote.address = strings.TrimPrefix(uri, "unix:")
+	}
+
+	switch policy {
+	case WritePolicyAll, WritePolicyFirst, WritePolicyNone:
+	default:
+		return nil, fmt.Errorf("listen: unknown write policy %q", policy)
+	}
+
+	if err := remote.Connect(ctx); nil != err {
+		return nil, err
+	}
+
+	return remote, nil
+}
+
+// sharedListener a listening socket shared by every ListenRemote on the same
+// address. A reload connects the new upstream while the old one is still open,
+// sharing lets it take over the socket instead of binding it twice
+type sharedListener struct {
+	key      string
+	listener net.Listener
+	// owners newest last, accepted clients go to the newest
+	owners []*ListenRemote
+}
+
+var listeners = struct {
+	sync.Mutex
+	open map[string]*sharedListener
+}{open: map[string]*sharedListener{}}
+
+// Connect open the listening socket, or take over the one already open on
+// the same address
+func (remote *ListenRemote) Connect(ctx context.Context) error {
+	log.Printf("listen:open uri=%v policy=%v\n", remote.uri, remote.policy)
+
+	listeners.Lock()
+	defer listeners.Unlock()
+
+	key := remote.network + ":" + remote.address
+	shared, ok := listeners.open[key]
+
+	if !ok {
+		if "unix" == remote.network {
+			// remove stale socket left by an earlier run, never one still in
+			// use as those are shared above
+			_ = os.Remove(remote.address)
+		}
+
+		config := &net.ListenConfig{}
+		listener, err := config.Listen(ctx, remote.network, remote.address)
+
+		if nil != err {
+			return err
+		}
+
+		shared = &sharedListener{key: key, listener: listener}
+		listeners.open[key] = shared
+
+		go shared.accept()
+	}
+
+	remote.channel = make(chan []byte, 256)
+	remote.shared = shared
+	shared.owners = append(shared.owners, remote)
+
+	return nil
+}
+
+// release stop `remote` from receiving clients, the socket is closed once no
+// ListenRemote uses it
+func (remote *ListenRemote) release() error {
+	listeners.Lock()
+	defer listeners.Unlock()
+
+	shared := remote.shared
+	if nil == shared {
+		return nil
+	}
+
+	remote.shared = nil
+
+	for i, owner := range shared.owners {
+		if owner == remote {
+			shared.owners = append(shared.owners[:i], shared.owners[i+1:]...)
+			break
+		}
+	}
+
+	if len(shared.owners) > 0 {
+		return nil
+	}
+
+	delete(listeners.open, shared.key)
+
+	return shared.listener.Close()
+}
+
+func (shared *sharedListener) accept() {
+	defer func() {
+		if err := recover(); nil != err {
+			log.Printf("error[listen] - %v", err)
+		}
+	}()
+
+	for {
+		client, err := shared.listener.Accept()
+
+		listeners.Lock()
+		owners := append([]*ListenRemote{}, shared.owners...)
+		listeners.Unlock()
+
+		if nil != err {
+			log.Printf("error[listen:accept] %v\n", err)
+
+			// closed on release when nobody is left to tell
+			for _, owner := range owners {
+				owner.channel <- []byte("")
+			}
+
+			return
+		}
+
+		if 0 == len(owners) {
+			client.Close()
+			continue
+		}
+
+		remote := owners[len(owners)-1]
+
+		log.Printf("listen:accept remote=%v\n", client.RemoteAddr())
+
+		remote.lock.Lock()
+		remote.clients = append(remote.clients, client)
+		remote.lock.Unlock()
+
+		go remote.read(client, remote.channel)
+	}
+}
+
+func (remote *ListenRemote) read(client net.Conn, channel chan []byte) {
+	defer func() {
+		if err := recover(); nil != err {
+			log.Printf("error[listen] - %v", err)
+		}
+	}()
+
+	// each client is framed separately so packets from different clients are
+	// never interleaved
+	framer := NewFramer(fmt.Sprintf("listen[%v]", client.RemoteAddr()))
+
+	for {
+		buf := make([]byte, 256)
+		n, err := client.Read(buf)
+
+		if nil != err {
+			log.Printf("listen:close remote=%v: %v\n", client.RemoteAddr(), err)
+			remote.drop(client)
+			return
+		}
+
+		if !remote.mayWrite(client) {
+			log.Printf("listen:recv remote=%v: client not allowed to write, discarding %v bytes\n", client.RemoteAddr(), n)
+			continue
+		}
+
+		for _, frame := range framer.Push(buf[:n]) {
+			channel <- frame
+		}
+	}
+}
+
+func (remote *ListenRemote) mayWrite(client net.Conn) bool {
+	switch remote.policy {
+	case WritePolicyAll:
+		return true
+
+	case WritePolicyFirst:
+		remote.lock.Lock()
+		defer remote.lock.Unlock()
+
+		return len(remote.clients) > 0 && client == remote.clients[0]
+	}
+
+	return false
+}
+
+func (remote *ListenRemote) drop(client net.Conn) {
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	for i, c := range remote.clients {
+		if c == client {
+			remote.clients = append(remote.clients[:i], remote.clients[i+1:]...)
+			break
+		}
+	}
+
+	client.Close()
+}
+
+// Channel return the listen channel
+func (remote *ListenRemote) Channel() chan []byte {
+	return remote.channel
+}
+
+// Close disconnect all clients and stop listening, unless the socket has been
+// taken over by another ListenRemote
+func (remote *ListenRemote) Close() error {
+	remote.lock.Lock()
+	clients := remote.clients
+	remote.clients = nil
+	remote.lock.Unlock()
+
+	for _, client := range clients {
+		client.Close()
+	}
+
+	return remote.release()
+}
+
+// Recv attempt to receive maximum amount of bytes within duration `t`
+func (remote *ListenRemote) Recv(t time.Duration) ([]byte, error) {
+	select {
+	case buf := <-remote.channel:
+		if 0 == len(buf) {
+			return nil, errors.New("EOF")
+		}
+
+		return buf, nil
+
+	case <-time.After(t):
+		return []byte(""), nil
+	}
+}
+
+// Write send `buf` to all connected clients, clients that can not keep up are
+// disconnected
+func (remote *ListenRemote) Write(buf []byte, timeout time.Duration) (int, error) {
+	debugf("listen:write[%v] %v\n", len(buf), buf)
+
+	remote.lock.Lock()
+	clients := append([]net.Conn{}, remote.clients...)
+	remote.lock.Unlock()
+
+	for _, client := range clients {
+		client.SetWriteDeadline(time.Now().Add(listenWriteTimeout))
+
+		if _, err := client.Write(buf); nil != err {
+			log.Printf("listen:write remote=%v: %v\n", client.RemoteAddr(), err)
+			remote.drop(client)
+		}
+	}
+
+	return len(buf), nil
+}
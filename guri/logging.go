@@ -0,0 +1,37 @@
+package guri
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Log levels, debug also logs every frame relayed
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+)
+
+// 1 when frames are logged
+var logDebug int32 = 1
+
+// SetLogLevel change the log level, safe to call while running
+func SetLogLevel(level string) error {
+	switch level {
+	case LogDebug:
+		atomic.StoreInt32(&logDebug, 1)
+	case LogInfo:
+		atomic.StoreInt32(&logDebug, 0)
+	default:
+		return fmt.Errorf("log: unknown level '%v', must be debug or info", level)
+	}
+
+	return nil
+}
+
+// debugf log only at debug level
+func debugf(format string, args ...interface{}) {
+	if 1 == atomic.LoadInt32(&logDebug) {
+		log.Printf(format, args...)
+	}
+}
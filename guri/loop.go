@@ -1,94 +1,297 @@
 package guri
 
 import (
+	"context"
 	"log"
+	"sync"
 	"time"
 )
 
-func forward(remote Remote, t time.Duration) chan []byte {
-	ch := make(chan []byte, 256)
+// retryInterval how often frames left behind by a failed write are retried
+const retryInterval = 5 * time.Second
 
-	go func() {
-		for {
-			buf, err := remote.Recv(t)
+// replay write queued frames to `sup`, stops at the first failed write
+func replay(queue *Queue, sup *supervisor) {
+	if queue.Len() > 0 {
+		log.Printf("%v:replay %v queued frames\n", sup.name, queue.Len())
+	}
+
+	for {
+		buf, ok := queue.Peek()
+
+		if !ok {
+			return
+		}
+
+		if _, err := sup.Write(buf); nil != err {
+			log.Printf("%v:replay: %v\n", sup.name, err)
+			return
+		}
+
+		queue.Pop()
+	}
+}
+
+// forwardJournaled append `buf` to the journal and forward it, after any older
+// frames still waiting; frames are acknowledged once the upstream write
+// succeeded
+func forwardJournaled(journal *Journal, sup *supervisor, buf []byte) {
+	seq, err := journal.Append(buf)
+
+	if nil != err {
+		log.Printf("journal:append: %v\n", err)
+		sup.Write(buf)
+		return
+	}
+
+	if !sup.Up() {
+		return
+	} else if journal.Pending() > 1 {
+		// a failed write left frames behind, send them, and `buf`, in order
+		replayJournal(journal, sup)
+		return
+	}
+
+	if _, err = sup.Write(buf); nil != err {
+		log.Printf("upstream:write: %v, keeping in journal\n", err)
+		return
+	}
+
+	if err = journal.Ack(seq); nil != err {
+		log.Printf("journal:ack: %v\n", err)
+	}
+}
+
+// replayJournal write all pending journal frames to `sup`
+func replayJournal(journal *Journal, sup *supervisor) {
+	if journal.Pending() > 0 {
+		log.Printf("upstream:replay %v journaled frames\n", journal.Pending())
+	}
+
+	err := journal.Replay(func(buf []byte) error {
+		_, err := sup.Write(buf)
+		return err
+	})
+
+	if nil != err {
+		log.Printf("upstream:replay: %v\n", err)
+	}
+}
+
+// shutdown wait for both supervisors to stop reading, flush frames that are
+// still buffered and close both remotes
+func shutdown(upstream, downstream *supervisor, queue, pending *Queue, journal *Journal) {
+	<-upstream.exited
+	<-downstream.exited
 
-			if nil != err {
-				log.Printf("forward-err: %v\n", err)
-				close(ch)
-				return
+	// frames read just before the supervisors stopped
+	for drained := false; !drained; {
+		select {
+		case buf := <-upstream.frames:
+			pending.Push(buf)
+
+		case buf := <-downstream.frames:
+			if nil != journal {
+				forwardJournaled(journal, upstream, buf)
+			} else {
+				queue.Push(buf)
 			}
 
-			ch <- buf
+		default:
+			drained = true
 		}
-	}()
+	}
+
+	if downstream.Up() {
+		replay(pending, downstream)
+	}
+
+	if nil != journal && upstream.Up() {
+		replayJournal(journal, upstream)
+	} else if upstream.Up() {
+		replay(queue, upstream)
+	}
+
+	if n := pending.Len(); n > 0 {
+		log.Printf("downstream:close: discarding %v undelivered frames\n", n)
+	}
+
+	if n := queue.Len(); n > 0 {
+		log.Printf("upstream:close: discarding %v undelivered frames\n", n)
+	}
+
+	if nil != journal {
+		if n := journal.Pending(); n > 0 {
+			log.Printf("upstream:close: %v frames kept in journal\n", n)
+		}
+
+		journal.Close()
+	}
 
-	return ch
+	if err := upstream.Close(); nil != err {
+		log.Printf("upstream:close: %v\n", err)
+	}
+
+	if err := downstream.Close(); nil != err {
+		log.Printf("downstream:close: %v\n", err)
+	}
 }
 
-// Loop run "event" loop
-func Loop(from Remote, to Remote, flags Flags) {
-	upstream := forward(from, 500*time.Millisecond)
-	downstream := forward(to, 2*time.Millisecond)
+// Loop run "event" loop until `ctx` is cancelled or a remote is lost for good,
+// pending frames are flushed and both remotes, and `journal` if not nil,
+// closed before returning. Remotes received on `reload` replace the upstream
+// without interrupting the serial side. Returns why a remote was given up, nil
+// when stopped through `ctx`
+func Loop(ctx context.Context, from Remote, to Remote, flags Flags, journal *Journal, reload <-chan Remote) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var failed error
+	var failOnce sync.Once
+
+	// shut down the same way as on a signal
+	giveUp := func(err error) {
+		failOnce.Do(func() { failed = err })
+		cancel()
+	}
 
-	upoff := &Backoff{
-		initial: 1 * time.Second,
-		wait:    1 * time.Second,
-		delay:   2.5,
-		max:     5 * time.Minute,
+	upstream := &supervisor{
+		name:      "upstream",
+		remote:    from,
+		timeout:   500 * time.Millisecond,
+		reconnect: flags.Reconnect,
+		frames:    make(chan []byte, 256),
+		state:     make(chan bool),
+		ctx:       ctx,
+		backoff:   NewBackoff(flags.Backoff),
+		giveUp:    giveUp,
 	}
 
-	downoff := &Backoff{
-		initial: 1 * time.Second,
-		wait:    1 * time.Second,
-		delay:   2.5,
-		max:     5 * time.Minute,
+	downstream := &supervisor{
+		name:      "downstream",
+		remote:    to,
+		timeout:   2 * time.Millisecond,
+		reconnect: flags.Reconnect,
+		frames:    make(chan []byte, 256),
+		state:     make(chan bool),
+		ctx:       ctx,
+		backoff:   NewBackoff(flags.Backoff),
+		giveUp:    giveUp,
+	}
+
+	// frames from the serial side are queued while the upstream is down, or
+	// journaled to disk when a journal is configured
+	queue := NewQueue(flags.Queue)
+
+	// commands from the upstream are held briefly while the serial side is
+	// reconnecting
+	pending := NewQueue(QueueOptions{MaxFrames: 256})
+
+	var hotplug <-chan HotplugEvent
+
+	if serial, ok := to.(*SerialRemote); ok && flags.HotplugInterval > 0 {
+		downstream.hotplug = WatchHotplug(ctx, serial.uri, flags.HotplugInterval)
+		hotplug = downstream.hotplug.Events()
 	}
 
+	upstream.start()
+	downstream.start()
+
+	if nil != journal {
+		// frames left over from an earlier run
+		replayJournal(journal, upstream)
+	}
+
+	retry := time.NewTicker(retryInterval)
+	defer retry.Stop()
+
 	for {
 		select {
-		case buf, state := <-upstream:
-			if false == state {
-				if !flags.Reconnect {
-					log.Fatalf("upstream:close, exiting")
-				}
+		case <-ctx.Done():
+			log.Printf("loop: %v, shutting down\n", ctx.Err())
+			shutdown(upstream, downstream, queue, pending, journal)
+			return failed
 
-				log.Printf("upstream:close, reconnecting\n")
-				from.Close()
-				if err := from.Connect(); nil != err {
-					log.Printf("upstream:open: %v\n", err)
-					upoff.Fail()
-				} else {
-					upoff.Success()
-					upstream = forward(from, 500*time.Millisecond)
-				}
-			} else if len(buf) > 0 {
-				log.Printf("upstream:recv[%v] %v\n", state, buf)
-				if len(buf) > 10 && 6 == buf[0] {
-					to.Write(buf[:1], -1)
-					to.Write(buf[1:], -1)
-				} else {
-					to.Write(buf, -1)
-				}
+		case buf := <-upstream.frames:
+			debugf("upstream:recv %v\n", buf)
+
+			if downstream.Up() && pending.Len() > 0 {
+				// a failed write left frames behind, send those first
+				replay(pending, downstream)
 			}
 
-		case buf, state := <-downstream:
-			if false == state {
-				if !flags.Reconnect {
-					log.Fatalf("upstream:close, exiting")
-				}
+			if !downstream.Up() || pending.Len() > 0 {
+				pending.Push(buf)
+			} else if _, err := downstream.Write(buf); nil != err {
+				log.Printf("downstream:write: %v, holding\n", err)
+				pending.Push(buf)
+			}
 
-				to.Close()
-				log.Printf("downstream:close, reconnecting\n")
-				if err := to.Connect(); nil != err {
-					log.Printf("downstream:open: %v\n", err)
-					downoff.Fail()
-				} else {
-					downoff.Success()
-					downstream = forward(to, 2*time.Millisecond)
+		case buf := <-downstream.frames:
+			debugf("downstream:recv %v\n", buf)
+
+			if nil != journal {
+				forwardJournaled(journal, upstream, buf)
+				continue
+			}
+
+			if upstream.Up() && queue.Len() > 0 {
+				// a failed write left frames behind, send those first
+				replay(queue, upstream)
+			}
+
+			if !upstream.Up() || queue.Len() > 0 {
+				queue.Push(buf)
+			} else if _, err := upstream.Write(buf); nil != err {
+				log.Printf("upstream:write: %v, queueing\n", err)
+				queue.Push(buf)
+			}
+
+		case <-retry.C:
+			// writes can fail while the remote still looks up, so frames
+			// left behind are retried instead of waiting for a reconnect
+			if upstream.Up() && nil != journal && journal.Pending() > 0 {
+				replayJournal(journal, upstream)
+			} else if upstream.Up() && queue.Len() > 0 {
+				replay(queue, upstream)
+			}
+
+			if downstream.Up() && pending.Len() > 0 {
+				replay(pending, downstream)
+			}
+
+		case remote := <-reload:
+			log.Printf("upstream:reload, replacing remote\n")
+			upstream.replace(remote)
+
+			if nil != journal {
+				replayJournal(journal, upstream)
+			} else {
+				replay(queue, upstream)
+			}
+
+		case up := <-upstream.state:
+			if up && nil != journal {
+				replayJournal(journal, upstream)
+			} else if up {
+				replay(queue, upstream)
+			}
+
+		case ev := <-hotplug:
+			if !ev.Present && downstream.Up() {
+				// do not wait for a read error to notice the device is gone
+				downstream.Close()
+			}
+
+			if notifier, ok := upstream.current().(Notifier); ok {
+				if err := notifier.Notify(ev.String()); nil != err {
+					log.Printf("upstream:notify: %v\n", err)
 				}
-			} else if len(buf) > 0 {
-				log.Printf("downstream:recv[%v] %v\n", state, buf)
-				from.Write(buf, -1)
+			}
+
+		case up := <-downstream.state:
+			if up {
+				replay(pending, downstream)
 			}
 		}
 	}
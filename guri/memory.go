@@ -0,0 +1,309 @@
+package guri
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Register a named location in configuration or calibration memory
+type Register struct {
+	Name   string
+	Offset int
+	Size   int
+}
+
+// ConfigRegisters named registers in configuration memory
+var ConfigRegisters = []Register{
+	{"rf_channel", 0, 1},
+	{"rf_power", 1, 1},
+	{"rf_data_rate", 2, 1},
+	{"protocol_mode", 3, 1},
+	{"rssi_threshold", 4, 1},
+	{"rssi_clear_channel", 5, 1},
+	{"max_jump_level", 6, 1},
+	{"max_jump_count", 7, 1},
+	{"max_packet_latency", 8, 1},
+	{"max_random_delay", 9, 1},
+	{"device_type", 14, 1},
+	{"uart_baud_rate", 15, 1},
+	{"uid", 45, 4},
+	{"sid", 49, 4},
+}
+
+// CalibrationRegisters named registers in calibration memory
+var CalibrationRegisters = []Register{
+	{"nid", 23, 4},
+}
+
+// ConfigMemorySize minimum number of bytes in a configuration memory dump
+const ConfigMemorySize = 53
+
+// CalibrationMemorySize minimum number of bytes in a calibration memory dump
+const CalibrationMemorySize = 27
+
+// ConfigMemory decoded configuration memory of a Tinymesh module
+type ConfigMemory struct {
+	RFChannel        byte    `json:"rf_channel"`
+	RFPower          byte    `json:"rf_power"`
+	RFDataRate       byte    `json:"rf_data_rate"`
+	ProtocolMode     byte    `json:"protocol_mode"`
+	RSSIThreshold    byte    `json:"rssi_threshold"`
+	RSSIClearChannel byte    `json:"rssi_clear_channel"`
+	MaxJumpLevel     byte    `json:"max_jump_level"`
+	MaxJumpCount     byte    `json:"max_jump_count"`
+	MaxPacketLatency byte    `json:"max_packet_latency"`
+	MaxRandomDelay   byte    `json:"max_random_delay"`
+	DeviceType       byte    `json:"device_type"`
+	UARTBaudRate     byte    `json:"uart_baud_rate"`
+	UID              Address `json:"uid"`
+	SID              Address `json:"sid"`
+
+	// raw memory dump, holds the registers without a name
+	raw []byte
+}
+
+// CalibrationMemory decoded calibration memory of a Tinymesh module
+type CalibrationMemory struct {
+	NID Address `json:"nid"`
+
+	// raw memory dump, holds the registers without a name
+	raw []byte
+}
+
+// MemoryChange a single byte that differs between two memory dumps
+type MemoryChange struct {
+	Register string
+	Offset   int
+	From     byte
+	To       byte
+}
+
+// MemoryChanges list of changes
+type MemoryChanges []MemoryChange
+
+// ParseConfigMemory decode a configuration memory dump
+func ParseConfigMemory(buf []byte) (*ConfigMemory, error) {
+	if len(buf) < ConfigMemorySize {
+		return nil, fmt.Errorf("tinymesh:memory: configuration memory too short (%v < %v)", len(buf), ConfigMemorySize)
+	}
+
+	raw := make([]byte, len(buf))
+	copy(raw, buf)
+
+	return &ConfigMemory{
+		RFChannel:        raw[0],
+		RFPower:          raw[1],
+		RFDataRate:       raw[2],
+		ProtocolMode:     raw[3],
+		RSSIThreshold:    raw[4],
+		RSSIClearChannel: raw[5],
+		MaxJumpLevel:     raw[6],
+		MaxJumpCount:     raw[7],
+		MaxPacketLatency: raw[8],
+		MaxRandomDelay:   raw[9],
+		DeviceType:       raw[14],
+		UARTBaudRate:     raw[15],
+		UID:              Address(append([]byte{}, raw[45:49]...)),
+		SID:              Address(append([]byte{}, raw[49:53]...)),
+		raw:              raw,
+	}, nil
+}
+
+// Bytes the memory dump with all named registers applied
+func (mem *ConfigMemory) Bytes() []byte {
+	raw := make([]byte, len(mem.raw))
+	copy(raw, mem.raw)
+
+	raw[0] = mem.RFChannel
+	raw[1] = mem.RFPower
+	raw[2] = mem.RFDataRate
+	raw[3] = mem.ProtocolMode
+	raw[4] = mem.RSSIThreshold
+	raw[5] = mem.RSSIClearChannel
+	raw[6] = mem.MaxJumpLevel
+	raw[7] = mem.MaxJumpCount
+	raw[8] = mem.MaxPacketLatency
+	raw[9] = mem.MaxRandomDelay
+	raw[14] = mem.DeviceType
+	raw[15] = mem.UARTBaudRate
+	copy(raw[45:49], mem.UID)
+	copy(raw[49:53], mem.SID)
+
+	return raw
+}
+
+// Clone deep copy of `mem`
+func (mem *ConfigMemory) Clone() *ConfigMemory {
+	clone, _ := ParseConfigMemory(mem.Bytes())
+	return clone
+}
+
+// ConfigValues all named registers as pairs for SetConfigurationMemory
+func (mem *ConfigMemory) ConfigValues() []ConfigValue {
+	return registerValues(ConfigRegisters, mem.Bytes())
+}
+
+// Diff changes required to turn `mem` into `target`
+func (mem *ConfigMemory) Diff(target *ConfigMemory) MemoryChanges {
+	return diffMemory(ConfigRegisters, mem.Bytes(), target.Bytes())
+}
+
+// ParseCalibrationMemory decode a calibration memory dump
+func ParseCalibrationMemory(buf []byte) (*CalibrationMemory, error) {
+	if len(buf) < CalibrationMemorySize {
+		return nil, fmt.Errorf("tinymesh:memory: calibration memory too short (%v < %v)", len(buf), CalibrationMemorySize)
+	}
+
+	raw := make([]byte, len(buf))
+	copy(raw, buf)
+
+	return &CalibrationMemory{
+		NID: Address(append([]byte{}, raw[23:27]...)),
+		raw: raw,
+	}, nil
+}
+
+// Bytes the memory dump with all named registers applied
+func (mem *CalibrationMemory) Bytes() []byte {
+	raw := make([]byte, len(mem.raw))
+	copy(raw, mem.raw)
+
+	copy(raw[23:27], mem.NID)
+
+	return raw
+}
+
+// Clone deep copy of `mem`
+func (mem *CalibrationMemory) Clone() *CalibrationMemory {
+	clone, _ := ParseCalibrationMemory(mem.Bytes())
+	return clone
+}
+
+// ConfigValues all named registers as pairs for SetCalibrationMemory
+func (mem *CalibrationMemory) ConfigValues() []ConfigValue {
+	return registerValues(CalibrationRegisters, mem.Bytes())
+}
+
+// Diff changes required to turn `mem` into `target`
+func (mem *CalibrationMemory) Diff(target *CalibrationMemory) MemoryChanges {
+	return diffMemory(CalibrationRegisters, mem.Bytes(), target.Bytes())
+}
+
+// ConfigValues changes as pairs for SetConfigurationMemory/SetCalibrationMemory
+func (changes MemoryChanges) ConfigValues() []ConfigValue {
+	pairs := []ConfigValue{}
+
+	for _, change := range changes {
+		pairs = append(pairs, ConfigValue{byte(change.Offset), change.To})
+	}
+
+	return pairs
+}
+
+// String "pretty" format of a change
+func (change MemoryChange) String() string {
+	return fmt.Sprintf("%v (%v): %v -> %v", change.Register, change.Offset, change.From, change.To)
+}
+
+// RegisterName name of the register at `offset`, multi byte registers are
+// suffixed with the byte index
+func RegisterName(registers []Register, offset int) string {
+	for _, reg := range registers {
+		if offset >= reg.Offset && offset < reg.Offset+reg.Size {
+			if 1 == reg.Size {
+				return reg.Name
+			}
+
+			return fmt.Sprintf("%v[%v]", reg.Name, offset-reg.Offset)
+		}
+	}
+
+	return fmt.Sprintf("0x%02x", offset)
+}
+
+func registerValues(registers []Register, raw []byte) []ConfigValue {
+	pairs := []ConfigValue{}
+
+	for _, reg := range registers {
+		for i := reg.Offset; i < reg.Offset+reg.Size; i++ {
+			pairs = append(pairs, ConfigValue{byte(i), raw[i]})
+		}
+	}
+
+	return pairs
+}
+
+func diffMemory(registers []Register, from []byte, to []byte) MemoryChanges {
+	changes := MemoryChanges{}
+
+	for i := 0; i < len(from) && i < len(to); i++ {
+		if from[i] != to[i] {
+			changes = append(changes, MemoryChange{
+				Register: RegisterName(registers, i),
+				Offset:   i,
+				From:     from[i],
+				To:       to[i],
+			})
+		}
+	}
+
+	return changes
+}
+
+// ReadConfigMemory read and decode configuration memory, `remote` must be in
+// configuration mode
+func ReadConfigMemory(ctx context.Context, remote Remote) (*ConfigMemory, error) {
+	if err := RunConfigCmd(ctx, remote, '0', false); err != nil {
+		return nil, fmt.Errorf("failed to request configuration memory: %v", err)
+	}
+
+	buf, err := remote.Recv(255 * time.Millisecond)
+	if nil != err {
+		return nil, fmt.Errorf("failed to read configuration memory: %v", err)
+	}
+
+	return ParseConfigMemory(buf)
+}
+
+// ReadCalibrationMemory read and decode calibration memory, `remote` must be
+// in configuration mode
+func ReadCalibrationMemory(ctx context.Context, remote Remote) (*CalibrationMemory, error) {
+	if err := RunConfigCmd(ctx, remote, 'r', false); err != nil {
+		return nil, fmt.Errorf("failed to request calibration memory: %v", err)
+	}
+
+	buf, err := remote.Recv(255 * time.Millisecond)
+	if nil != err {
+		return nil, fmt.Errorf("failed to read calibration memory: %v", err)
+	}
+
+	return ParseCalibrationMemory(buf)
+}
+
+// HexBytes raw bytes encoded as a hexadecimal string in JSON
+type HexBytes []byte
+
+// MarshalJSON encode bytes as a hexadecimal string
+func (buf HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(buf))
+}
+
+// UnmarshalJSON decode bytes from a hexadecimal string
+func (buf *HexBytes) UnmarshalJSON(data []byte) error {
+	var str string
+
+	if err := json.Unmarshal(data, &str); nil != err {
+		return err
+	}
+
+	decoded, err := hex.DecodeString(str)
+	if nil != err {
+		return err
+	}
+
+	*buf = decoded
+	return nil
+}
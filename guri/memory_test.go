@@ -0,0 +1,124 @@
+package guri
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testConfigDump() []byte {
+	buf := make([]byte, ConfigMemorySize+8)
+
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+
+	return buf
+}
+
+func TestParseConfigMemory(t *testing.T) {
+	dump := testConfigDump()
+
+	mem, err := ParseConfigMemory(dump)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  byte
+		want byte
+	}{
+		{"rf_channel", mem.RFChannel, 0},
+		{"rf_power", mem.RFPower, 1},
+		{"max_random_delay", mem.MaxRandomDelay, 9},
+		{"device_type", mem.DeviceType, 14},
+		{"uart_baud_rate", mem.UARTBaudRate, 15},
+	}
+
+	for _, test := range tests {
+		if test.want != test.got {
+			t.Errorf("%v decoded as %v, expected %v", test.name, test.got, test.want)
+		}
+	}
+
+	if !(Address{45, 46, 47, 48}).Equal(mem.UID) || !(Address{49, 50, 51, 52}).Equal(mem.SID) {
+		t.Errorf("uid/sid decoded as %v/%v", mem.UID, mem.SID)
+	}
+
+	// unnamed registers survive the round trip
+	if !bytes.Equal(dump, mem.Bytes()) {
+		t.Errorf("round trip gave %v, expected %v", mem.Bytes(), dump)
+	}
+
+	// the parsed memory does not share the dump
+	dump[0] = 99
+	if 0 != mem.RFChannel || 0 != mem.Bytes()[0] {
+		t.Error("memory changed with the dump it was parsed from")
+	}
+
+	if _, err := ParseConfigMemory(dump[:ConfigMemorySize-1]); nil == err {
+		t.Error("short configuration memory accepted")
+	}
+}
+
+func TestParseCalibrationMemory(t *testing.T) {
+	dump := make([]byte, CalibrationMemorySize)
+	copy(dump[23:], []byte{1, 2, 3, 4})
+
+	mem, err := ParseCalibrationMemory(dump)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if !(Address{1, 2, 3, 4}).Equal(mem.NID) {
+		t.Errorf("nid decoded as %v", mem.NID)
+	}
+
+	mem.NID = Address{5, 6, 7, 8}
+	if !bytes.Equal([]byte{5, 6, 7, 8}, mem.Bytes()[23:27]) {
+		t.Errorf("nid encoded as %v", mem.Bytes()[23:27])
+	}
+
+	if _, err := ParseCalibrationMemory(dump[:CalibrationMemorySize-1]); nil == err {
+		t.Error("short calibration memory accepted")
+	}
+}
+
+func TestConfigMemoryDiff(t *testing.T) {
+	from, _ := ParseConfigMemory(testConfigDump())
+
+	to := from.Clone()
+	to.RFChannel = 5
+	to.UID = Address{45, 46, 0, 48}
+
+	raw := to.Bytes()
+	raw[20] = 0xff
+	to, _ = ParseConfigMemory(raw)
+
+	want := MemoryChanges{
+		{Register: "rf_channel", Offset: 0, From: 0, To: 5},
+		{Register: "0x14", Offset: 20, From: 20, To: 0xff},
+		{Register: "uid[2]", Offset: 47, From: 47, To: 0},
+	}
+
+	changes := from.Diff(to)
+
+	if len(want) != len(changes) {
+		t.Fatalf("diff is %v, expected %v", changes, want)
+	}
+
+	for i := range want {
+		if want[i] != changes[i] {
+			t.Errorf("change %v is %v, expected %v", i, changes[i], want[i])
+		}
+	}
+
+	pairs := changes.ConfigValues()
+	if 3 != len(pairs) || !bytes.Equal([]byte{47, 0}, pairs[2]) {
+		t.Errorf("config values %v", pairs)
+	}
+
+	if 0 != len(from.Diff(from.Clone())) {
+		t.Error("memory differs from its clone")
+	}
+}
@@ -0,0 +1,199 @@
+package guri
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Packet types found at offset 16 of a Tinymesh packet
+const (
+	PacketTypeEvent  = 2
+	PacketTypeSerial = 16
+)
+
+// Event detail codes found at offset 17 of a generic event
+const (
+	EventIOChange         = 1
+	EventAIO0Change       = 2
+	EventAIO1Change       = 3
+	EventTamper           = 6
+	EventPowerOn          = 8
+	EventIMA              = 9
+	EventNetworkBusy      = 10
+	EventNetworkAvailable = 11
+	EventChannelJamming   = 12
+	EventAck              = 16
+	EventNak              = 17
+	EventNID              = 18
+	EventNextReceiver     = 19
+)
+
+var eventNames = map[byte]string{
+	EventIOChange:         "io_change",
+	EventAIO0Change:       "aio0_change",
+	EventAIO1Change:       "aio1_change",
+	EventTamper:           "tamper",
+	EventPowerOn:          "power_on",
+	EventIMA:              "ima",
+	EventNetworkBusy:      "network_busy",
+	EventNetworkAvailable: "network_available",
+	EventChannelJamming:   "channel_jamming",
+	EventAck:              "ack",
+	EventNak:              "nak",
+	EventNID:              "nid",
+	EventNextReceiver:     "next_receiver",
+}
+
+// EventName human readable name of an event detail code
+func EventName(detail byte) string {
+	if name, ok := eventNames[detail]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%v)", detail)
+}
+
+// Packet a decoded Tinymesh packet; one of *GenericEvent, *CommandAck,
+// *SerialData or *ConfigPrompt
+type Packet interface {
+	packet()
+}
+
+// Header fields shared by all packets sent by a Tinymesh gateway
+type Header struct {
+	SID          Address
+	UID          Address
+	RSSI         byte
+	NetworkLevel byte
+	Hops         byte
+	PacketNumber uint16
+	Latency      uint16
+	PacketType   byte
+}
+
+// GenericEvent a generic TM event
+type GenericEvent struct {
+	Header
+	Detail     byte
+	Data       []byte
+	Address    Address
+	Temp       int
+	Volt       float32
+	DigitalIO  byte
+	AIO0       uint16
+	AIO1       uint16
+	HWRevision []byte
+	FWRevision []byte
+}
+
+// CommandAck acknowledgement, or rejection, of a command sent to a node
+type CommandAck struct {
+	GenericEvent
+	Ack bool
+}
+
+// SerialData serial payload sent by a node
+type SerialData struct {
+	Header
+	BlockCounter byte
+	Data         []byte
+}
+
+// ConfigPrompt the `>` prompt written by a module in configuration mode
+type ConfigPrompt struct{}
+
+func (*GenericEvent) packet() {}
+func (*CommandAck) packet()   {}
+func (*SerialData) packet()   {}
+func (*ConfigPrompt) packet() {}
+
+// Decode decode a single, complete packet
+func Decode(buf []byte) (Packet, error) {
+	if 1 == len(buf) && '>' == buf[0] {
+		return &ConfigPrompt{}, nil
+	} else if len(buf) < 17 {
+		return nil, errors.New("tinymesh:decode: packet incomplete")
+	} else if int(buf[0]) != len(buf) {
+		return nil, fmt.Errorf("tinymesh:decode: length invalid (%v vs %v)", buf[0], len(buf))
+	}
+
+	switch buf[16] {
+	case PacketTypeEvent:
+		ev, err := DecodeEvent(buf)
+
+		if nil != err {
+			return nil, err
+		}
+
+		if EventAck == ev.Detail || EventNak == ev.Detail {
+			return &CommandAck{GenericEvent: ev, Ack: EventAck == ev.Detail}, nil
+		}
+
+		return &ev, nil
+
+	case PacketTypeSerial:
+		return DecodeSerialData(buf)
+	}
+
+	return nil, fmt.Errorf("tinymesh:decode: unknown packet type %v", buf[16])
+}
+
+func decodeHeader(buf []byte) Header {
+	return Header{
+		SID:          buf[1:5],
+		UID:          buf[5:9],
+		RSSI:         buf[9],
+		NetworkLevel: buf[10],
+		Hops:         buf[11],
+		PacketNumber: (uint16(buf[12]) << 8) + uint16(buf[13]),
+		Latency:      (uint16(buf[14]) << 8) + uint16(buf[15]),
+		PacketType:   buf[16],
+	}
+}
+
+// DecodeEvent decode a generic event, regardless of detail code
+func DecodeEvent(buf []byte) (GenericEvent, error) {
+	if len(buf) != 35 {
+		return GenericEvent{}, errors.New("expected a generic Tinymesh event, incomplete")
+	} else if buf[0] != 35 {
+		return GenericEvent{}, errors.New("expected a generic Tinymesh event, length invalid")
+	} else if buf[16] != PacketTypeEvent {
+		return GenericEvent{}, errors.New("expected a generic Tinymesh event, packetType /= 2")
+	}
+
+	return GenericEvent{
+		Header:     decodeHeader(buf),
+		Detail:     buf[17],
+		Data:       buf[18:20],
+		Address:    buf[20:24],
+		Temp:       int(buf[24]) - 128,
+		Volt:       float32(buf[25]) * 0.030,
+		DigitalIO:  buf[26],
+		AIO0:       (uint16(buf[27]) << 8) + uint16(buf[28]),
+		AIO1:       (uint16(buf[29]) << 8) + uint16(buf[30]),
+		HWRevision: buf[31:33],
+		FWRevision: buf[33:35],
+	}, nil
+}
+
+// DecodeSerialData decode a serial data packet
+func DecodeSerialData(buf []byte) (*SerialData, error) {
+	if len(buf) < 19 {
+		return nil, errors.New("expected a Tinymesh serial packet, incomplete")
+	} else if int(buf[0]) != len(buf) {
+		return nil, errors.New("expected a Tinymesh serial packet, length invalid")
+	} else if buf[16] != PacketTypeSerial {
+		return nil, errors.New("expected a Tinymesh serial packet, packetType /= 16")
+	}
+
+	return &SerialData{
+		Header:       decodeHeader(buf),
+		BlockCounter: buf[17],
+		Data:         buf[18:],
+	}, nil
+}
+
+// EventName name of the event detail
+func (ev *GenericEvent) EventName() string {
+	return EventName(ev.Detail)
+}
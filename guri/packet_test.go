@@ -0,0 +1,186 @@
+package guri
+
+import (
+	"bytes"
+	"testing"
+)
+
+// header of a packet sent by node 05:06:07:08 in system 01:02:03:04
+func testHeader(length int, packetType byte) []byte {
+	return []byte{
+		byte(length),
+		1, 2, 3, 4,
+		5, 6, 7, 8,
+		200, 2, 1,
+		0x01, 0x02,
+		0x00, 0x10,
+		packetType,
+	}
+}
+
+func testEvent(detail byte) []byte {
+	buf := testHeader(35, PacketTypeEvent)
+
+	return append(buf,
+		detail,
+		0xab, 0xcd,
+		0x0a, 0x0b, 0x0c, 0x0d,
+		150,
+		100,
+		0x81,
+		0x01, 0x02,
+		0x03, 0x04,
+		1, 2,
+		1, 40,
+	)
+}
+
+func testSerialData(data []byte) []byte {
+	buf := testHeader(18+len(data), PacketTypeSerial)
+	return append(append(buf, 7), data...)
+}
+
+func TestDecodeEvent(t *testing.T) {
+	packet, err := Decode(testEvent(EventIOChange))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	ev, ok := packet.(*GenericEvent)
+	if !ok {
+		t.Fatalf("decoded as %T, expected *GenericEvent", packet)
+	}
+
+	want := Header{
+		SID:          Address{1, 2, 3, 4},
+		UID:          Address{5, 6, 7, 8},
+		RSSI:         200,
+		NetworkLevel: 2,
+		Hops:         1,
+		PacketNumber: 0x0102,
+		Latency:      0x0010,
+		PacketType:   PacketTypeEvent,
+	}
+
+	if !want.SID.Equal(ev.SID) || !want.UID.Equal(ev.UID) || want.RSSI != ev.RSSI ||
+		want.NetworkLevel != ev.NetworkLevel || want.Hops != ev.Hops ||
+		want.PacketNumber != ev.PacketNumber || want.Latency != ev.Latency || want.PacketType != ev.PacketType {
+		t.Errorf("header decoded as %+v, expected %+v", ev.Header, want)
+	}
+
+	if EventIOChange != ev.Detail || "io_change" != ev.EventName() {
+		t.Errorf("detail decoded as %v (%v)", ev.Detail, ev.EventName())
+	}
+
+	if !bytes.Equal([]byte{0xab, 0xcd}, ev.Data) || !(Address{0x0a, 0x0b, 0x0c, 0x0d}).Equal(ev.Address) {
+		t.Errorf("data/address decoded as %v/%v", ev.Data, ev.Address)
+	}
+
+	if 22 != ev.Temp || 0x81 != ev.DigitalIO || 0x0102 != ev.AIO0 || 0x0304 != ev.AIO1 {
+		t.Errorf("io decoded as temp=%v dio=%v aio0=%v aio1=%v", ev.Temp, ev.DigitalIO, ev.AIO0, ev.AIO1)
+	}
+
+	if ev.Volt < 2.99 || ev.Volt > 3.01 {
+		t.Errorf("voltage decoded as %v, expected 3.0", ev.Volt)
+	}
+
+	if !bytes.Equal([]byte{1, 2}, ev.HWRevision) || !bytes.Equal([]byte{1, 40}, ev.FWRevision) {
+		t.Errorf("revisions decoded as %v/%v", ev.HWRevision, ev.FWRevision)
+	}
+}
+
+func TestDecodeCommandAck(t *testing.T) {
+	tests := []struct {
+		detail byte
+		ack    bool
+	}{
+		{EventAck, true},
+		{EventNak, false},
+	}
+
+	for _, test := range tests {
+		packet, err := Decode(testEvent(test.detail))
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		ack, ok := packet.(*CommandAck)
+		if !ok {
+			t.Errorf("%v: decoded as %T, expected *CommandAck", EventName(test.detail), packet)
+		} else if test.ack != ack.Ack || test.detail != ack.Detail {
+			t.Errorf("%v: decoded as ack=%v detail=%v", EventName(test.detail), ack.Ack, ack.Detail)
+		}
+	}
+}
+
+func TestDecodeSerialData(t *testing.T) {
+	for _, data := range [][]byte{{0x42}, []byte("hello"), bytes.Repeat([]byte{0xaa}, MaxPacketLength-18)} {
+		packet, err := Decode(testSerialData(data))
+		if nil != err {
+			t.Fatalf("%v bytes: %v", len(data), err)
+		}
+
+		serial, ok := packet.(*SerialData)
+		if !ok {
+			t.Fatalf("%v bytes: decoded as %T, expected *SerialData", len(data), packet)
+		}
+
+		if 7 != serial.BlockCounter || !bytes.Equal(data, serial.Data) || !(Address{5, 6, 7, 8}).Equal(serial.UID) {
+			t.Errorf("%v bytes: decoded as %+v", len(data), serial)
+		}
+	}
+}
+
+func TestDecodeConfigPrompt(t *testing.T) {
+	if packet, err := Decode([]byte{'>'}); nil != err {
+		t.Fatal(err)
+	} else if _, ok := packet.(*ConfigPrompt); !ok {
+		t.Fatalf("decoded as %T, expected *ConfigPrompt", packet)
+	}
+}
+
+// packets coming off the serial port pass the framer before being decoded
+func TestDecodeFramed(t *testing.T) {
+	packets := [][]byte{testEvent(EventPowerOn), testSerialData([]byte("abc")), testEvent(EventAck)}
+	framer := NewFramer("test")
+
+	frames := framer.Push(join(packets...))
+	if len(frames) != len(packets) {
+		t.Fatalf("got %v frames, expected %v", len(frames), len(packets))
+	}
+
+	for i, frame := range frames {
+		if _, err := Decode(frame); nil != err {
+			t.Errorf("frame %v: %v", i, err)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	badLength := testEvent(EventIOChange)
+	badLength[0] = 34
+
+	unknownType := testEvent(EventIOChange)
+	unknownType[16] = 99
+
+	shortEvent := testHeader(20, PacketTypeEvent)
+	shortEvent = append(shortEvent, 1, 2, 3)
+
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		{"incomplete", testEvent(EventIOChange)[:16]},
+		{"length mismatch", badLength},
+		{"unknown type", unknownType},
+		{"short event", shortEvent},
+		{"serial without payload", testHeader(18, PacketTypeSerial)[:17]},
+	}
+
+	for _, test := range tests {
+		if packet, err := Decode(test.buf); nil == err {
+			t.Errorf("%v: decoded as %+v, expected an error", test.name, packet)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package guri
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SerialPort a serialport and, for USB adapters, its identity
+type SerialPort struct {
+	Name         string
+	IsUSB        bool
+	VID          string
+	PID          string
+	SerialNumber string
+}
+
+// PortSelector picks a serialport by USB identity rather than path, empty
+// fields match anything
+type PortSelector struct {
+	VID          string
+	PID          string
+	SerialNumber string
+}
+
+const portSelectorPrefix = "usb:"
+
+// IsPortSelector true if `uri` is a selector rather than a path
+func IsPortSelector(uri string) bool {
+	return strings.HasPrefix(uri, portSelectorPrefix)
+}
+
+// ParsePortSelector parse a selector like usb:vid=0403,pid=6001,serial=ABC123
+func ParsePortSelector(uri string) (*PortSelector, error) {
+	if !IsPortSelector(uri) {
+		return nil, fmt.Errorf("serial:select: %v: selector must start with %v", uri, portSelectorPrefix)
+	}
+
+	selector := &PortSelector{}
+
+	for _, part := range strings.Split(strings.TrimPrefix(uri, portSelectorPrefix), ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || "" == kv[1] {
+			return nil, fmt.Errorf("serial:select: %v: expected key=value, got '%v'", uri, part)
+		}
+
+		switch strings.ToLower(kv[0]) {
+		case "vid":
+			selector.VID = kv[1]
+		case "pid":
+			selector.PID = kv[1]
+		case "serial":
+			selector.SerialNumber = kv[1]
+		default:
+			return nil, fmt.Errorf("serial:select: %v: unknown key '%v', must be vid, pid or serial", uri, kv[0])
+		}
+	}
+
+	if "" == selector.VID && "" == selector.PID && "" == selector.SerialNumber {
+		return nil, fmt.Errorf("serial:select: %v: no vid, pid or serial given", uri)
+	}
+
+	return selector, nil
+}
+
+// Match true if `port` is a USB port with the selected identity
+func (selector *PortSelector) Match(port SerialPort) bool {
+	if !port.IsUSB {
+		return false
+	} else if "" != selector.VID && !strings.EqualFold(selector.VID, port.VID) {
+		return false
+	} else if "" != selector.PID && !strings.EqualFold(selector.PID, port.PID) {
+		return false
+	} else if "" != selector.SerialNumber && selector.SerialNumber != port.SerialNumber {
+		return false
+	}
+
+	return true
+}
+
+// ResolvePort path of the serialport `uri` refers to, either a path or a
+// selector matching exactly one port
+func ResolvePort(uri string) (string, error) {
+	if !IsPortSelector(uri) {
+		return uri, nil
+	}
+
+	selector, err := ParsePortSelector(uri)
+	if nil != err {
+		return "", err
+	}
+
+	ports, err := PortList()
+	if nil != err {
+		return "", fmt.Errorf("serial:select: %v", err)
+	}
+
+	var matches []string
+
+	for _, port := range ports {
+		if selector.Match(port) {
+			matches = append(matches, port.Name)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("serial:select: no serialport matches %v", uri)
+	case 1:
+		return matches[0], nil
+	}
+
+	return "", fmt.Errorf("serial:select: %v matches several serialports (%v), add serial=", uri, strings.Join(matches, ", "))
+}
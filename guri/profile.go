@@ -0,0 +1,226 @@
+package guri
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Profile desired register values for a module, registers not mentioned are
+// left untouched. Values are numbers for single byte registers and addresses
+// (ie, aa:bb:cc:dd) for 4 byte registers
+type Profile struct {
+	Configuration map[string]interface{} `json:"configuration" yaml:"configuration"`
+	Calibration   map[string]interface{} `json:"calibration" yaml:"calibration"`
+}
+
+// MemoryUpdate changes made, or to be made, to module memory
+type MemoryUpdate struct {
+	Configuration MemoryChanges
+	Calibration   MemoryChanges
+}
+
+// LoadProfile read profile from a JSON or YAML (.yml, .yaml) file
+func LoadProfile(path string) (*Profile, error) {
+	buf, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+
+	profile := &Profile{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(buf, profile)
+	default:
+		err = json.Unmarshal(buf, profile)
+	}
+
+	if nil != err {
+		return nil, fmt.Errorf("profile: failed to parse %v: %v", path, err)
+	}
+
+	return profile, nil
+}
+
+// Targets apply the profile to the memory dumps `cfg` and `cal`, returning the
+// desired memory contents
+func (profile *Profile) Targets(cfg []byte, cal []byte) ([]byte, []byte, error) {
+	cfgTarget, err := applyRegisters(ConfigRegisters, cfg, profile.Configuration)
+	if nil != err {
+		return nil, nil, fmt.Errorf("profile: configuration: %v", err)
+	}
+
+	calTarget, err := applyRegisters(CalibrationRegisters, cal, profile.Calibration)
+	if nil != err {
+		return nil, nil, fmt.Errorf("profile: calibration: %v", err)
+	}
+
+	return cfgTarget, calTarget, nil
+}
+
+func applyRegisters(registers []Register, raw []byte, values map[string]interface{}) ([]byte, error) {
+	target := make([]byte, len(raw))
+	copy(target, raw)
+
+	for name, value := range values {
+		reg, err := lookupRegister(registers, name)
+		if nil != err {
+			return nil, err
+		}
+
+		if reg.Offset+reg.Size > len(target) {
+			return nil, fmt.Errorf("register %v outside of memory (%v bytes)", name, len(target))
+		}
+
+		buf, err := registerValue(reg, value)
+		if nil != err {
+			return nil, fmt.Errorf("register %v: %v", name, err)
+		}
+
+		copy(target[reg.Offset:], buf)
+	}
+
+	return target, nil
+}
+
+// lookupRegister find register by name, unnamed single byte registers may be
+// given by offset (ie, 0x1f)
+func lookupRegister(registers []Register, name string) (Register, error) {
+	for _, reg := range registers {
+		if reg.Name == name {
+			return reg, nil
+		}
+	}
+
+	if offset, err := strconv.ParseUint(name, 0, 8); nil == err {
+		return Register{name, int(offset), 1}, nil
+	}
+
+	return Register{}, fmt.Errorf("unknown register %q", name)
+}
+
+func registerValue(reg Register, value interface{}) ([]byte, error) {
+	if AddressLength == reg.Size {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected an address, got %v", value)
+		}
+
+		addr := ParseAddr(str)
+		if nil == addr {
+			return nil, fmt.Errorf("invalid address %q", str)
+		}
+
+		return addr, nil
+	}
+
+	var n int64
+
+	switch v := value.(type) {
+	case int:
+		n = int64(v)
+	case float64:
+		n = int64(v)
+		if float64(n) != v {
+			return nil, fmt.Errorf("expected an integer, got %v", v)
+		}
+	case string:
+		parsed, err := strconv.ParseInt(v, 0, 64)
+		if nil != err {
+			return nil, err
+		}
+		n = parsed
+	default:
+		return nil, fmt.Errorf("expected a number, got %v", value)
+	}
+
+	if n < 0 || n > 255 {
+		return nil, fmt.Errorf("value %v out of range 0-255", n)
+	}
+
+	return []byte{byte(n)}, nil
+}
+
+// UpdateMemory write the registers where `cfg` and `cal`, as just read from
+// the module, differ from `cfgTarget` and `calTarget` and re-read memory to
+// verify. When `dryRun` is set nothing is written. `remote` must be in
+// configuration mode
+func UpdateMemory(ctx context.Context, remote Remote, cfg *ConfigMemory, cal *CalibrationMemory, cfgTarget []byte, calTarget []byte, dryRun bool) (*MemoryUpdate, error) {
+	var err error
+
+	update := &MemoryUpdate{
+		Configuration: diffMemory(ConfigRegisters, cfg.Bytes(), cfgTarget),
+		Calibration:   diffMemory(CalibrationRegisters, cal.Bytes(), calTarget),
+	}
+
+	if dryRun {
+		return update, nil
+	}
+
+	if len(update.Configuration) > 0 {
+		log.Println("serial:config: set configuration")
+		if err = SetConfigurationMemory(ctx, remote, update.Configuration.ConfigValues()); nil != err {
+			return update, fmt.Errorf("serial:config: failed to set configuration memory: %v", err)
+		}
+	}
+
+	if len(update.Calibration) > 0 {
+		log.Println("serial:config: set calibration")
+		if err = SetCalibrationMemory(ctx, remote, update.Calibration.ConfigValues()); nil != err {
+			return update, fmt.Errorf("serial:config: failed to set calibration memory: %v", err)
+		}
+	}
+
+	if cfg, err = ReadConfigMemory(ctx, remote); nil != err {
+		return update, fmt.Errorf("serial:config: verify: %v", err)
+	} else if changes := diffMemory(ConfigRegisters, cfg.Bytes(), cfgTarget); len(changes) > 0 {
+		return update, fmt.Errorf("serial:config: verify: configuration memory differs: %v", changes)
+	}
+
+	if cal, err = ReadCalibrationMemory(ctx, remote); nil != err {
+		return update, fmt.Errorf("serial:config: verify: %v", err)
+	} else if changes := diffMemory(CalibrationRegisters, cal.Bytes(), calTarget); len(changes) > 0 {
+		return update, fmt.Errorf("serial:config: verify: calibration memory differs: %v", changes)
+	}
+
+	return update, nil
+}
+
+// ApplyProfile bring the module on `remote` in line with `profile`. The module
+// is put in configuration mode and left again before returning
+func ApplyProfile(ctx context.Context, remote *SerialRemote, profile *Profile, dryRun bool) (update *MemoryUpdate, err error) {
+	if err = WaitForTinyMeshConfig(ctx, remote); nil != err {
+		return nil, err
+	}
+
+	defer func() {
+		if exitErr := RunConfigCmd(ctx, remote, 'X', false); nil != exitErr && nil == err {
+			err = fmt.Errorf("serial:config: failed to exit configuration mode: %v", exitErr)
+		}
+	}()
+
+	cfg, err := ReadConfigMemory(ctx, remote)
+	if nil != err {
+		return nil, fmt.Errorf("serial:config: %v", err)
+	}
+
+	cal, err := ReadCalibrationMemory(ctx, remote)
+	if nil != err {
+		return nil, fmt.Errorf("serial:config: %v", err)
+	}
+
+	cfgTarget, calTarget, err := profile.Targets(cfg.Bytes(), cal.Bytes())
+	if nil != err {
+		return nil, err
+	}
+
+	return UpdateMemory(ctx, remote, cfg, cal, cfgTarget, calTarget, dryRun)
+}
@@ -0,0 +1,141 @@
+package guri
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyRegisters(t *testing.T) {
+	raw := make([]byte, ConfigMemorySize)
+
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		offset int
+		want   []byte
+	}{
+		{"yaml int", map[string]interface{}{"rf_channel": 5}, 0, []byte{5}},
+		{"json float", map[string]interface{}{"rf_power": float64(7)}, 1, []byte{7}},
+		{"hex string", map[string]interface{}{"device_type": "0x1f"}, 14, []byte{0x1f}},
+		{"decimal string", map[string]interface{}{"device_type": "255"}, 14, []byte{255}},
+		{"offset name", map[string]interface{}{"0x1f": 3}, 0x1f, []byte{3}},
+		{"decimal offset name", map[string]interface{}{"20": 4}, 20, []byte{4}},
+		{"address", map[string]interface{}{"sid": "01:02:03:04"}, 49, []byte{1, 2, 3, 4}},
+	}
+
+	for _, test := range tests {
+		target, err := applyRegisters(ConfigRegisters, raw, test.values)
+		if nil != err {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		if got := target[test.offset : test.offset+len(test.want)]; !bytes.Equal(test.want, got) {
+			t.Errorf("%v: wrote %v, expected %v", test.name, got, test.want)
+		}
+	}
+
+	if !bytes.Equal(make([]byte, ConfigMemorySize), raw) {
+		t.Error("applyRegisters modified its input")
+	}
+}
+
+func TestApplyRegistersInvalid(t *testing.T) {
+	raw := make([]byte, ConfigMemorySize)
+
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+	}{
+		{"unknown register", map[string]interface{}{"rf_channels": 1}},
+		{"offset too large", map[string]interface{}{"0x100": 1}},
+		{"outside memory", map[string]interface{}{"0x40": 1}},
+		{"too large", map[string]interface{}{"rf_channel": 256}},
+		{"negative", map[string]interface{}{"rf_channel": -1}},
+		{"negative float", map[string]interface{}{"rf_channel": float64(-1)}},
+		{"fraction", map[string]interface{}{"rf_channel": 1.5}},
+		{"hex too large", map[string]interface{}{"rf_channel": "0x100"}},
+		{"not a number", map[string]interface{}{"rf_channel": "one"}},
+		{"bool", map[string]interface{}{"rf_channel": true}},
+		{"address as number", map[string]interface{}{"uid": 1}},
+		{"invalid address", map[string]interface{}{"uid": "01:02:zz:04"}},
+	}
+
+	for _, test := range tests {
+		if target, err := applyRegisters(ConfigRegisters, raw, test.values); nil == err {
+			t.Errorf("%v: applied as %v, expected an error", test.name, target)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"module.yaml": "configuration:\n  rf_channel: 5\n  device_type: 0x10\n  '0x1f': \"0x20\"\n  sid: 01:02:03:04\ncalibration:\n  nid: 05:06:07:08\n",
+		"module.json": `{"configuration": {"rf_channel": 5, "device_type": 16, "0x1f": "0x20", "sid": "01:02:03:04"}, "calibration": {"nid": "05:06:07:08"}}`,
+	}
+
+	cfg := make([]byte, ConfigMemorySize)
+	cal := make([]byte, CalibrationMemorySize)
+
+	wantCfg := make([]byte, ConfigMemorySize)
+	wantCfg[0], wantCfg[14], wantCfg[0x1f] = 5, 0x10, 0x20
+	copy(wantCfg[49:], []byte{1, 2, 3, 4})
+
+	wantCal := make([]byte, CalibrationMemorySize)
+	copy(wantCal[23:], []byte{5, 6, 7, 8})
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := ioutil.WriteFile(path, []byte(content), 0600); nil != err {
+			t.Fatal(err)
+		}
+
+		profile, err := LoadProfile(path)
+		if nil != err {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+
+		cfgTarget, calTarget, err := profile.Targets(cfg, cal)
+		if nil != err {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+
+		if !bytes.Equal(wantCfg, cfgTarget) {
+			t.Errorf("%v: configuration %v, expected %v", name, cfgTarget, wantCfg)
+		}
+
+		if !bytes.Equal(wantCal, calTarget) {
+			t.Errorf("%v: calibration %v, expected %v", name, calTarget, wantCal)
+		}
+	}
+}
+
+func TestProfileTargetsInvalid(t *testing.T) {
+	cfg := make([]byte, ConfigMemorySize)
+	cal := make([]byte, CalibrationMemorySize)
+
+	tests := []struct {
+		profile Profile
+		want    string
+	}{
+		{Profile{Configuration: map[string]interface{}{"rf_channel": 300}}, "profile: configuration: register rf_channel:"},
+		{Profile{Calibration: map[string]interface{}{"nid": 1}}, "profile: calibration: register nid:"},
+		{Profile{Calibration: map[string]interface{}{"rf_channel": 1}}, "profile: calibration: unknown register"},
+	}
+
+	for _, test := range tests {
+		if _, _, err := test.profile.Targets(cfg, cal); nil == err {
+			t.Errorf("%+v: expected an error", test.profile)
+		} else if !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%+v: error %q, expected %q", test.profile, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package guri
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy settings for Flags.Proxy, anything else is a proxy URL
+const (
+	// ProxyEnv use HTTPS_PROXY or ALL_PROXY, minus the hosts in NO_PROXY
+	ProxyEnv = ""
+	// ProxyDirect never use a proxy
+	ProxyDirect = "direct"
+)
+
+// dialTimeout time allowed for connecting to an upstream or proxy
+const dialTimeout = 30 * time.Second
+
+// proxyTimeout time allowed for the proxy handshake, on top of the dial
+const proxyTimeout = 30 * time.Second
+
+// warnHTTPSProxy logs once that a https:// proxy from the environment is
+// ignored
+var warnHTTPSProxy sync.Once
+
+// ProxyURL the proxy to use for reaching `addr`, nil to connect directly.
+// `proxy` is ProxyEnv, ProxyDirect or a http://, socks5:// or socks5h:// URL;
+// a bare host:port is a http proxy
+func ProxyURL(proxy string, addr string) (*url.URL, error) {
+	fromEnv := false
+
+	switch proxy {
+	case ProxyDirect:
+		return nil, nil
+
+	case ProxyEnv:
+		host, _, err := net.SplitHostPort(addr)
+		if nil != err {
+			return nil, err
+		}
+
+		if noProxy(host, getenv("NO_PROXY", "no_proxy")) {
+			return nil, nil
+		}
+
+		proxy = getenv("HTTPS_PROXY", "https_proxy")
+		if "" == proxy {
+			proxy = getenv("ALL_PROXY", "all_proxy")
+		}
+
+		if "" == proxy {
+			return nil, nil
+		}
+
+		fromEnv = true
+	}
+
+	if !strings.Contains(proxy, "://") {
+		// like net/http, ie. HTTPS_PROXY=proxy.corp:3128
+		proxy = "http://" + proxy
+	}
+
+	u, err := url.Parse(proxy)
+	if nil != err || "" == u.Host {
+		return nil, fmt.Errorf("proxy: invalid proxy URL '%v', expected ie. http://host:port or socks5://host:port", proxy)
+	}
+
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+	case "https":
+		if fromEnv {
+			// do not break hosts that worked before proxy support
+			warnHTTPSProxy.Do(func() {
+				log.Printf("proxy: https proxies are not supported, ignoring %v and connecting directly\n", redactProxy(u))
+			})
+
+			return nil, nil
+		}
+
+		fallthrough
+	default:
+		return nil, fmt.Errorf("proxy: unsupported proxy scheme '%v', must be http, socks5 or socks5h", u.Scheme)
+	}
+
+	return u, nil
+}
+
+// ValidateProxy check a proxy setting
+func ValidateProxy(proxy string) error {
+	if ProxyEnv == proxy || ProxyDirect == proxy {
+		return nil
+	}
+
+	_, err := ProxyURL(proxy, "")
+	return err
+}
+
+// getenv first non-empty environment variable of `names`
+func getenv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); "" != value {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// noProxy true if `host` matches the NO_PROXY list `list`: *, host names
+// (matching subdomains too), IP addresses and CIDR ranges
+func noProxy(host string, list string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		if "*" == entry {
+			return true
+		} else if h, _, err := net.SplitHostPort(entry); nil == err {
+			entry = h
+		}
+
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+
+		if "" == entry {
+			continue
+		}
+
+		if _, network, err := net.ParseCIDR(entry); nil == err {
+			if nil != ip && network.Contains(ip) {
+				return true
+			}
+		} else if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dialUpstream open a `network` connection to `addr`, tunnelled through the
+// proxy selected by `proxy` for tcp
+func dialUpstream(ctx context.Context, network, addr, proxy string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	if "tcp" != network {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	u, err := ProxyURL(proxy, addr)
+	if nil != err {
+		return nil, err
+	} else if nil == u {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	log.Printf("proxy:open proxy=%v uri=%v\n", redactProxy(u), addr)
+
+	proxyAddr := u.Host
+	if "" == u.Port() {
+		proxyAddr = net.JoinHostPort(u.Hostname(), defaultProxyPort(u.Scheme))
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if nil != err {
+		return nil, fmt.Errorf("proxy: %v", err)
+	}
+
+	// bound the handshake, and abort it when `ctx` is cancelled
+	conn.SetDeadline(time.Now().Add(proxyTimeout))
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	var tunnel net.Conn
+
+	if "http" == u.Scheme {
+		tunnel, err = httpConnect(conn, u, addr)
+	} else {
+		tunnel, err = socks5Connect(ctx, conn, u, addr)
+	}
+
+	if nil == err && nil != ctx.Err() {
+		err = ctx.Err()
+	}
+
+	if nil != err {
+		conn.Close()
+		return nil, fmt.Errorf("proxy: %v: %v", redactProxy(u), err)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return tunnel, nil
+}
+
+func defaultProxyPort(scheme string) string {
+	if "http" == scheme {
+		return "8080"
+	}
+
+	return "1080"
+}
+
+// redactProxy proxy URL without the password, for logging
+func redactProxy(u *url.URL) string {
+	if _, ok := u.User.Password(); ok {
+		redacted := *u
+		redacted.User = url.UserPassword(u.User.Username(), "xxxxx")
+		return redacted.String()
+	}
+
+	return u.String()
+}
+
+// RedactProxy the proxy setting `proxy` without the password, for printing
+func RedactProxy(proxy string) string {
+	if u, err := url.Parse(proxy); nil == err && nil != u.User {
+		return redactProxy(u)
+	}
+
+	return proxy
+}
+
+// httpConnect open a tunnel to `addr` with a HTTP CONNECT request
+func httpConnect(conn net.Conn, u *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+
+	if nil != u.User {
+		password, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if err := req.Write(conn); nil != err {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(reader, req)
+	if nil != err {
+		return nil, err
+	}
+
+	res.Body.Close()
+
+	if http.StatusOK != res.StatusCode {
+		return nil, fmt.Errorf("CONNECT %v refused: %v", addr, res.Status)
+	}
+
+	if reader.Buffered() > 0 {
+		// the upstream spoke first, keep what was read along with the response
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn net.Conn reading through a bufio.Reader first
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(buf []byte) (int, error) {
+	return conn.reader.Read(buf)
+}
+
+// SOCKS5, RFC 1928 and RFC 1929
+const (
+	socksVersion     = 0x05
+	socksNoAuth      = 0x00
+	socksUserPass    = 0x02
+	socksNoMethods   = 0xff
+	socksCmdConnect  = 0x01
+	socksAddrIPv4    = 0x01
+	socksAddrDomain  = 0x03
+	socksAddrIPv6    = 0x04
+	socksAuthVersion = 0x01
+)
+
+var socksReplies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socks5Connect open a tunnel to `addr` through a SOCKS5 proxy. socks5h
+// leaves name resolution to the proxy, socks5 resolves `addr` locally
+func socks5Connect(ctx context.Context, conn net.Conn, u *url.URL, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if nil != err {
+		return nil, err
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if nil != err || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in %v", addr)
+	}
+
+	methods := []byte{socksNoAuth}
+	if nil != u.User {
+		methods = append(methods, socksUserPass)
+	}
+
+	if _, err = conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); nil != err {
+		return nil, err
+	}
+
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); nil != err {
+		return nil, err
+	} else if socksVersion != reply[0] {
+		return nil, fmt.Errorf("not a SOCKS5 proxy")
+	}
+
+	switch reply[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if err = socks5Auth(conn, u.User); nil != err {
+			return nil, err
+		}
+	case socksNoMethods:
+		return nil, errors.New("no acceptable authentication method, check the proxy credentials")
+	default:
+		return nil, fmt.Errorf("unsupported authentication method %v", reply[1])
+	}
+
+	req := []byte{socksVersion, socksCmdConnect, 0x00}
+
+	ip := net.ParseIP(host)
+	if nil == ip && "socks5" == u.Scheme {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if nil != err {
+			return nil, err
+		}
+
+		ip = addrs[0].IP
+	}
+
+	if ip4 := ip.To4(); nil != ip4 {
+		req = append(append(req, socksAddrIPv4), ip4...)
+	} else if nil != ip {
+		req = append(append(req, socksAddrIPv6), ip.To16()...)
+	} else if len(host) > 255 {
+		return nil, fmt.Errorf("host name %v too long", host)
+	} else {
+		req = append(append(req, socksAddrDomain, byte(len(host))), host...)
+	}
+
+	req = append(req, byte(port>>8), byte(port))
+
+	if _, err = conn.Write(req); nil != err {
+		return nil, err
+	}
+
+	// version, reply, reserved and address type
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(conn, head); nil != err {
+		return nil, err
+	} else if 0x00 != head[1] {
+		if msg, ok := socksReplies[head[1]]; ok {
+			return nil, fmt.Errorf("CONNECT %v refused: %v", addr, msg)
+		}
+
+		return nil, fmt.Errorf("CONNECT %v refused: reply %v", addr, head[1])
+	}
+
+	// skip the bound address and port
+	var skip int
+	switch head[3] {
+	case socksAddrIPv4:
+		skip = net.IPv4len
+	case socksAddrIPv6:
+		skip = net.IPv6len
+	case socksAddrDomain:
+		n := make([]byte, 1)
+		if _, err = io.ReadFull(conn, n); nil != err {
+			return nil, err
+		}
+
+		skip = int(n[0])
+	default:
+		return nil, fmt.Errorf("unknown address type %v in reply", head[3])
+	}
+
+	if _, err = io.ReadFull(conn, make([]byte, skip+2)); nil != err {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// socks5Auth username/password authentication
+func socks5Auth(conn net.Conn, user *url.Userinfo) error {
+	if nil == user {
+		return errors.New("proxy requires a username and password")
+	}
+
+	username := user.Username()
+	password, _ := user.Password()
+
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("username or password longer than 255 bytes")
+	}
+
+	req := []byte{socksAuthVersion, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+
+	if _, err := conn.Write(req); nil != err {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); nil != err {
+		return err
+	} else if 0x00 != reply[1] {
+		return errors.New("authentication failed")
+	}
+
+	return nil
+}
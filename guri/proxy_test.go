@@ -0,0 +1,365 @@
+package guri
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// listen run `serve` for every connection to a new local listener
+func listen(t *testing.T, serve func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if nil != err {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func echoServer(t *testing.T) string {
+	return listen(t, func(conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+}
+
+// relay connect `conn` to `addr` until either side closes
+func relay(conn net.Conn, reader io.Reader, addr string) {
+	upstream, err := net.Dial("tcp", addr)
+	if nil != err {
+		return
+	}
+
+	defer upstream.Close()
+
+	go io.Copy(upstream, reader)
+	io.Copy(conn, upstream)
+}
+
+// httpProxy CONNECT proxy requiring `auth` as user:pass when not empty
+func httpProxy(t *testing.T, auth string) string {
+	return listen(t, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+
+		req, err := http.ReadRequest(reader)
+		if nil != err || "CONNECT" != req.Method {
+			return
+		}
+
+		if "" != auth && "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)) != req.Header.Get("Proxy-Authorization") {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		relay(conn, reader, req.Host)
+	})
+}
+
+// socksProxy SOCKS5 proxy requiring `user` and `pass` when not empty. Every
+// requested target is sent on `targets`, `reply` other than 0 refuses it
+func socksProxy(t *testing.T, user, pass string, reply byte, targets chan<- string) string {
+	return listen(t, func(conn net.Conn) {
+		buf := make([]byte, 262)
+
+		// greeting
+		if _, err := io.ReadFull(conn, buf[:2]); nil != err {
+			return
+		}
+
+		nmethods := int(buf[1])
+		if _, err := io.ReadFull(conn, buf[:nmethods]); nil != err {
+			return
+		}
+
+		if "" == user {
+			conn.Write([]byte{socksVersion, socksNoAuth})
+		} else {
+			conn.Write([]byte{socksVersion, socksUserPass})
+
+			if _, err := io.ReadFull(conn, buf[:2]); nil != err {
+				return
+			}
+
+			ulen := int(buf[1])
+			if _, err := io.ReadFull(conn, buf[:ulen+1]); nil != err {
+				return
+			}
+
+			gotUser := string(buf[:ulen])
+			plen := int(buf[ulen])
+
+			if _, err := io.ReadFull(conn, buf[:plen]); nil != err {
+				return
+			}
+
+			if user != gotUser || pass != string(buf[:plen]) {
+				conn.Write([]byte{socksAuthVersion, 1})
+				return
+			}
+
+			conn.Write([]byte{socksAuthVersion, 0})
+		}
+
+		// request
+		if _, err := io.ReadFull(conn, buf[:4]); nil != err {
+			return
+		}
+
+		var host string
+
+		switch buf[3] {
+		case socksAddrIPv4:
+			io.ReadFull(conn, buf[:4])
+			host = net.IP(buf[:4]).String()
+
+		case socksAddrIPv6:
+			io.ReadFull(conn, buf[:16])
+			host = net.IP(buf[:16]).String()
+
+		case socksAddrDomain:
+			io.ReadFull(conn, buf[:1])
+			n := int(buf[0])
+			io.ReadFull(conn, buf[:n])
+			host = string(buf[:n])
+		}
+
+		io.ReadFull(conn, buf[:2])
+		port := int(buf[0])<<8 | int(buf[1])
+
+		targets <- net.JoinHostPort(host, strconv.Itoa(port))
+
+		conn.Write([]byte{socksVersion, reply, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+
+		if 0 == reply {
+			relay(conn, conn, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		}
+	})
+}
+
+// roundTrip check that bytes written to `conn` come back from the echo server
+func roundTrip(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); nil != err {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); nil != err || "ping" != string(buf) {
+		t.Fatalf("read %q (%v), expected ping", buf, err)
+	}
+}
+
+func TestDialHTTPConnect(t *testing.T) {
+	echo := echoServer(t)
+
+	tests := []struct {
+		name  string
+		auth  string
+		proxy string
+		ok    bool
+	}{
+		{"no auth", "", "http://%v", true},
+		{"basic auth", "user:secret", "http://user:secret@%v", true},
+		{"wrong password", "user:secret", "http://user:wrong@%v", false},
+		{"missing credentials", "user:secret", "http://%v", false},
+	}
+
+	for _, test := range tests {
+		proxy := fmt.Sprintf(test.proxy, httpProxy(t, test.auth))
+		conn, err := dialUpstream(context.Background(), "tcp", echo, proxy)
+
+		if !test.ok {
+			if nil == err {
+				conn.Close()
+				t.Errorf("%v: connected, expected the proxy to refuse", test.name)
+			}
+
+			continue
+		} else if nil != err {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		roundTrip(t, conn)
+	}
+}
+
+func TestDialSOCKS5(t *testing.T) {
+	echo := echoServer(t)
+	_, port, _ := net.SplitHostPort(echo)
+
+	tests := []struct {
+		name   string
+		scheme string
+		user   string
+		target string
+		reply  byte
+		sent   string
+	}{
+		{"ipv4", "socks5", "", echo, 0, echo},
+		{"resolved locally", "socks5", "", "localhost:" + port, 0, ""},
+		{"resolved by proxy", "socks5h", "", "localhost:" + port, 0, "localhost:" + port},
+		{"user and password", "socks5", "user", echo, 0, echo},
+		{"refused", "socks5", "", echo, 0x05, echo},
+	}
+
+	for _, test := range tests {
+		targets := make(chan string, 1)
+		addr := socksProxy(t, test.user, "secret", test.reply, targets)
+
+		proxy := test.scheme + "://" + addr
+		if "" != test.user {
+			proxy = test.scheme + "://" + test.user + ":secret@" + addr
+		}
+
+		conn, err := dialUpstream(context.Background(), "tcp", test.target, proxy)
+
+		if 0 != test.reply {
+			if nil == err {
+				conn.Close()
+				t.Errorf("%v: connected, expected the proxy to refuse", test.name)
+			}
+
+			continue
+		} else if nil != err {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		sent := <-targets
+		if "" != test.sent && test.sent != sent {
+			t.Errorf("%v: proxy asked for %v, expected %v", test.name, sent, test.sent)
+		} else if "" == test.sent {
+			// resolved locally to either loopback address
+			if host, _, _ := net.SplitHostPort(sent); nil == net.ParseIP(host) || !net.ParseIP(host).IsLoopback() {
+				t.Errorf("%v: proxy asked for %v, expected a loopback address", test.name, sent)
+			}
+		}
+
+		roundTrip(t, conn)
+	}
+}
+
+func TestDialSOCKS5WrongPassword(t *testing.T) {
+	addr := socksProxy(t, "user", "secret", 0, make(chan string, 1))
+
+	if conn, err := dialUpstream(context.Background(), "tcp", echoServer(t), "socks5://user:wrong@"+addr); nil == err {
+		conn.Close()
+		t.Fatal("connected with the wrong password")
+	}
+}
+
+func TestNoProxy(t *testing.T) {
+	tests := []struct {
+		host string
+		list string
+		skip bool
+	}{
+		{"example.com", "", false},
+		{"example.com", "*", true},
+		{"example.com", "example.com", true},
+		{"EXAMPLE.com", "example.COM", true},
+		{"tcp.example.com", "example.com", true},
+		{"tcp.example.com", ".example.com", true},
+		{"tcp.example.com", "*.example.com", true},
+		{"badexample.com", "example.com", false},
+		{"example.com", "other.com, example.com:7002", true},
+		{"10.1.2.3", "10.0.0.0/8", true},
+		{"192.168.1.1", "10.0.0.0/8", false},
+		{"2001:db8::1", "2001:db8::/32", true},
+		{"10.1.2.3", "10.1.2.3", true},
+		{"example.com", "10.0.0.0/8", false},
+	}
+
+	for _, test := range tests {
+		if skip := noProxy(test.host, test.list); test.skip != skip {
+			t.Errorf("noProxy(%v, %q) = %v, expected %v", test.host, test.list, skip, test.skip)
+		}
+	}
+}
+
+func TestProxyURLFromEnv(t *testing.T) {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+
+	t.Setenv("ALL_PROXY", "socks5://all:1080")
+	t.Setenv("NO_PROXY", "internal.example.com")
+
+	tests := []struct {
+		name   string
+		proxy  string
+		addr   string
+		expect string
+	}{
+		{"all proxy", ProxyEnv, "tcp.cloud.tiny-mesh.com:7002", "socks5://all:1080"},
+		{"no proxy", ProxyEnv, "gw.internal.example.com:7002", ""},
+		{"direct", ProxyDirect, "tcp.cloud.tiny-mesh.com:7002", ""},
+		{"explicit", "http://explicit:3128", "gw.internal.example.com:7002", "http://explicit:3128"},
+	}
+
+	for _, test := range tests {
+		u, err := ProxyURL(test.proxy, test.addr)
+
+		got := ""
+		if nil != u {
+			got = u.String()
+		}
+
+		if nil != err {
+			t.Errorf("%v: %v", test.name, err)
+		} else if test.expect != got {
+			t.Errorf("%v: got %q, expected %q", test.name, got, test.expect)
+		}
+	}
+
+	// HTTPS_PROXY takes precedence
+	t.Setenv("HTTPS_PROXY", "http://https:3128")
+
+	if u, _ := ProxyURL(ProxyEnv, "tcp.cloud.tiny-mesh.com:7002"); nil == u || "http://https:3128" != u.String() {
+		t.Errorf("got %v, expected HTTPS_PROXY", u)
+	}
+
+	// a bare host:port is a http proxy, as in net/http
+	t.Setenv("HTTPS_PROXY", "proxy.corp:3128")
+
+	if u, err := ProxyURL(ProxyEnv, "tcp.cloud.tiny-mesh.com:7002"); nil == u || "http://proxy.corp:3128" != u.String() {
+		t.Errorf("got %v (%v), expected http://proxy.corp:3128", u, err)
+	}
+
+	// https proxies are not supported, skipped rather than failing every connect
+	t.Setenv("HTTPS_PROXY", "https://proxy.corp:3128")
+
+	if u, err := ProxyURL(ProxyEnv, "tcp.cloud.tiny-mesh.com:7002"); nil != u || nil != err {
+		t.Errorf("got %v (%v), expected a direct connection", u, err)
+	}
+
+	for _, proxy := range []string{"ftp://proxy:21", "https://proxy:3128", "not a url", "http://"} {
+		if err := ValidateProxy(proxy); nil == err {
+			t.Errorf("%v: expected an error", proxy)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package guri
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Drop policies for Queue, decides which frame is lost when the queue is full
+const (
+	DropOldest = "oldest"
+	DropNewest = "newest"
+)
+
+// QueueOptions limits for the store-and-forward queue, zero means unlimited
+type QueueOptions struct {
+	MaxFrames  int
+	MaxBytes   int
+	MaxAge     time.Duration
+	DropPolicy string
+	// SpillDir directory for frames that do not fit in memory, empty
+	// disables spilling to disk
+	SpillDir      string
+	MaxSpillBytes int64
+}
+
+// Queue bounded FIFO of frames waiting for the upstream. Frames that do not fit
+// in memory are spilled to disk, when both are full frames are dropped
+// according to the drop policy
+type Queue struct {
+	opts   QueueOptions
+	frames []queuedFrame
+	bytes  int
+
+	spillPath   string
+	spillWriter *os.File
+	spillReader *os.File
+	// bytes written to, and read from, the spill file
+	spillWritten int64
+	spillRead    int64
+	spillFrames  int
+
+	dropped int
+}
+
+type queuedFrame struct {
+	at  time.Time
+	buf []byte
+}
+
+// size of the timestamp and length prefixing every spilled frame
+const spillHeaderSize = 12
+
+// NewQueue create a queue limited by `opts`
+func NewQueue(opts QueueOptions) *Queue {
+	if DropNewest != opts.DropPolicy {
+		opts.DropPolicy = DropOldest
+	}
+
+	queue := &Queue{opts: opts}
+
+	if "" != opts.SpillDir {
+		queue.spillPath = filepath.Join(opts.SpillDir, fmt.Sprintf("guri-queue-%v.spill", os.Getpid()))
+	}
+
+	return queue
+}
+
+// Len number of queued frames
+func (queue *Queue) Len() int {
+	return len(queue.frames) + queue.spillFrames
+}
+
+// Push append `buf` to the queue
+func (queue *Queue) Push(buf []byte) {
+	frame := queuedFrame{at: time.Now(), buf: buf}
+
+	if queue.opts.MaxBytes > 0 && len(buf) > queue.opts.MaxBytes {
+		queue.drop(len(buf), "full")
+		return
+	}
+
+	// once frames are spilled new frames must follow them to keep the order
+	if 0 == queue.spillFrames && queue.memoryFits(len(buf)) {
+		queue.pushMemory(frame)
+		return
+	}
+
+	if queue.spillFits(len(buf)) {
+		if err := queue.spill(frame); nil == err {
+			return
+		}
+	}
+
+	if DropNewest == queue.opts.DropPolicy {
+		queue.drop(len(buf), "full")
+		return
+	}
+
+	// make room by dropping the oldest frame, shifting the oldest spilled frame
+	// into memory to keep memory ahead of disk
+	for queue.Len() > 0 {
+		dropped := queue.popHead()
+		queue.drop(len(dropped.buf), "full")
+
+		if queue.spillFrames > 0 {
+			if shifted, err := queue.unspill(); nil == err {
+				queue.pushMemory(shifted)
+			}
+		}
+
+		if 0 == queue.spillFrames && queue.memoryFits(len(buf)) {
+			queue.pushMemory(frame)
+			return
+		} else if queue.spillFrames > 0 && queue.spillFits(len(buf)) {
+			if err := queue.spill(frame); nil == err {
+				return
+			}
+		}
+	}
+
+	queue.drop(len(buf), "full")
+}
+
+// Peek oldest frame that has not expired, without removing it
+func (queue *Queue) Peek() ([]byte, bool) {
+	for {
+		if 0 == len(queue.frames) && queue.spillFrames > 0 {
+			frame, err := queue.unspill()
+			if nil != err {
+				log.Printf("queue:spill: %v, discarding %v spilled frames\n", err, queue.spillFrames)
+				queue.resetSpill()
+				continue
+			}
+
+			queue.pushMemory(frame)
+		}
+
+		if 0 == len(queue.frames) {
+			return nil, false
+		}
+
+		head := queue.frames[0]
+
+		if queue.opts.MaxAge > 0 && time.Since(head.at) > queue.opts.MaxAge {
+			queue.popHead()
+			queue.drop(len(head.buf), "expired")
+			continue
+		}
+
+		return head.buf, true
+	}
+}
+
+// Pop remove the oldest frame that has not expired
+func (queue *Queue) Pop() ([]byte, bool) {
+	buf, ok := queue.Peek()
+
+	if ok {
+		queue.popHead()
+	}
+
+	return buf, ok
+}
+
+func (queue *Queue) memoryFits(n int) bool {
+	if queue.opts.MaxFrames > 0 && len(queue.frames)+1 > queue.opts.MaxFrames {
+		return false
+	} else if queue.opts.MaxBytes > 0 && queue.bytes+n > queue.opts.MaxBytes {
+		return false
+	}
+
+	return true
+}
+
+func (queue *Queue) spillFits(n int) bool {
+	if "" == queue.spillPath {
+		return false
+	} else if queue.opts.MaxSpillBytes > 0 && queue.spillWritten-queue.spillRead+int64(n+spillHeaderSize) > queue.opts.MaxSpillBytes {
+		return false
+	}
+
+	return true
+}
+
+func (queue *Queue) pushMemory(frame queuedFrame) {
+	queue.frames = append(queue.frames, frame)
+	queue.bytes = queue.bytes + len(frame.buf)
+}
+
+func (queue *Queue) popHead() queuedFrame {
+	if 0 == len(queue.frames) {
+		frame, err := queue.unspill()
+		if nil != err {
+			queue.resetSpill()
+		}
+
+		return frame
+	}
+
+	head := queue.frames[0]
+	queue.frames = queue.frames[1:]
+	queue.bytes = queue.bytes - len(head.buf)
+
+	return head
+}
+
+func (queue *Queue) drop(n int, reason string) {
+	queue.dropped = queue.dropped + 1
+	log.Printf("queue: %v, dropped %v byte frame (%v dropped in total)\n", reason, n, queue.dropped)
+}
+
+func (queue *Queue) spill(frame queuedFrame) error {
+	if nil == queue.spillWriter {
+		writer, err := os.OpenFile(queue.spillPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+		if nil != err {
+			log.Printf("queue:spill: %v\n", err)
+			return err
+		}
+
+		reader, err := os.Open(queue.spillPath)
+		if nil != err {
+			writer.Close()
+			log.Printf("queue:spill: %v\n", err)
+			return err
+		}
+
+		queue.spillWriter = writer
+		queue.spillReader = reader
+	}
+
+	header := make([]byte, spillHeaderSize)
+	binary.BigEndian.PutUint64(header[0:8], uint64(frame.at.UnixNano()))
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(frame.buf)))
+
+	if _, err := queue.spillWriter.Write(append(header, frame.buf...)); nil != err {
+		log.Printf("queue:spill: %v\n", err)
+		return err
+	}
+
+	queue.spillWritten = queue.spillWritten + int64(spillHeaderSize+len(frame.buf))
+	queue.spillFrames = queue.spillFrames + 1
+
+	return nil
+}
+
+func (queue *Queue) unspill() (queuedFrame, error) {
+	header := make([]byte, spillHeaderSize)
+
+	if _, err := io.ReadFull(queue.spillReader, header); nil != err {
+		return queuedFrame{}, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+
+	if _, err := io.ReadFull(queue.spillReader, buf); nil != err {
+		return queuedFrame{}, err
+	}
+
+	queue.spillRead = queue.spillRead + int64(spillHeaderSize+len(buf))
+	queue.spillFrames = queue.spillFrames - 1
+
+	if 0 == queue.spillFrames {
+		queue.resetSpill()
+	}
+
+	return queuedFrame{
+		at:  time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8]))),
+		buf: buf,
+	}, nil
+}
+
+// resetSpill remove the spill file once it has been drained
+func (queue *Queue) resetSpill() {
+	if nil != queue.spillWriter {
+		queue.spillWriter.Close()
+		queue.spillReader.Close()
+		os.Remove(queue.spillPath)
+	}
+
+	queue.spillWriter = nil
+	queue.spillReader = nil
+	queue.spillWritten = 0
+	queue.spillRead = 0
+	queue.spillFrames = 0
+}
@@ -0,0 +1,101 @@
+package guri
+
+import (
+	"testing"
+	"time"
+)
+
+func drain(queue *Queue) []byte {
+	var bufs []byte
+
+	for {
+		buf, ok := queue.Pop()
+		if !ok {
+			return bufs
+		}
+
+		bufs = append(bufs, buf[0])
+	}
+}
+
+func TestQueueDropPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     QueueOptions
+		push     []byte
+		expected []byte
+	}{
+		{"unlimited", QueueOptions{}, []byte{1, 2, 3, 4}, []byte{1, 2, 3, 4}},
+		{"oldest", QueueOptions{MaxFrames: 2, DropPolicy: DropOldest}, []byte{1, 2, 3, 4}, []byte{3, 4}},
+		{"default is oldest", QueueOptions{MaxFrames: 2}, []byte{1, 2, 3, 4}, []byte{3, 4}},
+		{"newest", QueueOptions{MaxFrames: 2, DropPolicy: DropNewest}, []byte{1, 2, 3, 4}, []byte{1, 2}},
+		{"bytes oldest", QueueOptions{MaxBytes: 30, DropPolicy: DropOldest}, []byte{1, 2, 3, 4}, []byte{2, 3, 4}},
+		{"bytes newest", QueueOptions{MaxBytes: 30, DropPolicy: DropNewest}, []byte{1, 2, 3, 4}, []byte{1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		queue := NewQueue(test.opts)
+
+		for _, n := range test.push {
+			buf := make([]byte, 10)
+			buf[0] = n
+			queue.Push(buf)
+		}
+
+		if got := drain(queue); string(got) != string(test.expected) {
+			t.Errorf("%v: got %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestQueueOversizedFrame(t *testing.T) {
+	queue := NewQueue(QueueOptions{MaxBytes: 10})
+
+	queue.Push([]byte{1})
+	queue.Push(make([]byte, 11))
+
+	if got := drain(queue); string(got) != string([]byte{1}) {
+		t.Fatalf("got %v, expected only the frame that fits", got)
+	}
+}
+
+func TestQueueMaxAge(t *testing.T) {
+	queue := NewQueue(QueueOptions{MaxAge: 20 * time.Millisecond})
+
+	queue.Push([]byte{1})
+	time.Sleep(30 * time.Millisecond)
+	queue.Push([]byte{2})
+
+	if got := drain(queue); string(got) != string([]byte{2}) {
+		t.Fatalf("got %v, expected the expired frame to be dropped", got)
+	}
+}
+
+func TestQueueSpill(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     QueueOptions
+		expected []byte
+	}{
+		{"spill keeps order", QueueOptions{MaxFrames: 2, MaxSpillBytes: 1024}, []byte{1, 2, 3, 4, 5}},
+		{"spill full drops oldest", QueueOptions{MaxFrames: 2, MaxSpillBytes: 2 * (spillHeaderSize + 1)}, []byte{2, 3, 4, 5}},
+		{"spill full drops newest", QueueOptions{MaxFrames: 2, MaxSpillBytes: 2 * (spillHeaderSize + 1), DropPolicy: DropNewest}, []byte{1, 2, 3, 4}},
+	}
+
+	for _, test := range tests {
+		test.opts.SpillDir = t.TempDir()
+		queue := NewQueue(test.opts)
+
+		for n := byte(1); n <= 5; n++ {
+			queue.Push([]byte{n})
+		}
+
+		if queue.Len() != len(test.expected) {
+			t.Errorf("%v: %v frames queued, expected %v", test.name, queue.Len(), len(test.expected))
+		}
+
+		if got := drain(queue); string(got) != string(test.expected) {
+			t.Errorf("%v: got %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
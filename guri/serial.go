@@ -1,8 +1,11 @@
 package guri
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	serial "go.bug.st/serial.v1"
@@ -17,18 +20,30 @@ type SerialRemote struct {
 	channel chan []byte
 	// done channel, send something to close it
 	done chan struct{}
+	// inConfig set while the module is known to be in config mode
+	inConfig int32
+	// mode current line settings, timing is derived from it
+	mode *serial.Mode
+	// baud last detected baud rate when auto detecting
+	baud int
+	// idleAt time the last write has left the UART and the write gap passed
+	idleAt time.Time
 }
 
 // ConnectSerial connect to a serial device
-func ConnectSerial(uri string, flags Flags) (*SerialRemote, error) {
+func ConnectSerial(ctx context.Context, uri string, flags Flags) (*SerialRemote, error) {
 	remote := &SerialRemote{
 		uri:   uri,
 		flags: flags,
 	}
 
-	err := remote.Connect()
+	err := remote.Connect(ctx)
 
 	if nil != err {
+		if nil != remote.port {
+			remote.Close()
+		}
+
 		return nil, err
 	}
 
@@ -36,43 +51,148 @@ func ConnectSerial(uri string, flags Flags) (*SerialRemote, error) {
 }
 
 // Connect dial into serial device
-func (remote *SerialRemote) Connect() error {
-	log.Printf("serial:open uri=%v\n", remote.uri)
+func (remote *SerialRemote) Connect(ctx context.Context) error {
+	// selectors are resolved on every connect, the path may have changed
+	// since the adapter was last plugged in
+	path, err := ResolvePort(remote.uri)
+
+	if nil != err {
+		return err
+	}
+
+	log.Printf("serial:open uri=%v path=%v\n", remote.uri, path)
 
-	port, err := serial.Open(remote.uri, &serial.Mode{})
+	line := remote.flags.Line
+
+	baud := line.Baud
+	if 0 == baud && 0 != remote.baud {
+		baud = remote.baud
+	} else if 0 == baud {
+		baud = AutoBaudRates[0]
+	}
+
+	mode, err := line.Mode(baud)
 
 	if nil != err {
 		return err
 	}
 
-	mode := &serial.Mode{
-		BaudRate: 19200,
-		Parity:   serial.NoParity,
-		DataBits: 8,
-		StopBits: serial.OneStopBit,
+	port, err := serial.Open(path, &serial.Mode{})
+
+	if nil != err {
+		return err
 	}
 
 	if err := port.SetMode(mode); err != nil {
+		port.Close()
 		return err
 	}
 
+	if FlowRTSCTS == line.FlowControl {
+		// tell the module we are ready to receive
+		if err := port.SetRTS(true); nil != err {
+			port.Close()
+			return err
+		}
+	}
+
 	remote.port = port
+	remote.mode = mode
 	remote.done = make(chan struct{}, 2)
 	remote.channel = make(chan []byte, 256)
 
 	go remote.ioloop()
 
+	if 0 == line.Baud {
+		if err := remote.detectBaud(); nil != err {
+			return err
+		}
+	}
+
 	if true == remote.flags.AutoConfigure {
+		if "" != remote.flags.BackupDir {
+			if err := remote.backup(ctx, remote.flags.BackupDir); nil != err {
+				return err
+			}
+		}
+
 		// configureGateway this, flags
-		if err := ensureTinyMeshConfig(remote, remote.flags); nil != err {
+		if err := ensureTinyMeshConfig(ctx, remote, remote.flags); nil != err {
 			return err
 		}
 	} else if true == remote.flags.Verify {
-		if err := verifyTinyMeshConfig(remote, remote.flags); nil != err {
+		if err := verifyTinyMeshConfig(ctx, remote, remote.flags); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// detectBaud probe AutoBaudRates, starting with the last detected rate, until
+// the module answers
+func (remote *SerialRemote) detectBaud() error {
+	rates := []int{remote.mode.BaudRate}
+	for _, baud := range AutoBaudRates {
+		if baud != remote.mode.BaudRate {
+			rates = append(rates, baud)
+		}
+	}
+
+	for _, baud := range rates {
+		mode, err := remote.flags.Line.Mode(baud)
+		if nil != err {
+			return err
+		}
+
+		if err = remote.port.SetMode(mode); nil != err {
 			return err
 		}
+
+		remote.mode = mode
+		log.Printf("serial:baud: probing %v\n", baud)
+
+		// discard anything received at the previous rate
+		remote.port.ResetInputBuffer()
+		if _, err = remote.Recv(charTimes(mode, 20)); nil != err {
+			return err
+		}
+
+		if remote.answers() {
+			log.Printf("serial:baud: module answered at %v\n", baud)
+			remote.baud = baud
+			return nil
+		}
+	}
+
+	return fmt.Errorf("serial:baud: no answer from module at any of %v", rates)
+}
+
+// answers true if the module responds at the current baud rate, either with
+// the config prompt or with a network id
+func (remote *SerialRemote) answers() bool {
+	if inCfg, err := inTinyMeshConfig(remote); nil == err && inCfg {
+		remote.setConfigMode(true)
+		return true
+	}
+
+	_, err := RequestNID(remote)
+	return nil == err
+}
+
+// backup save memory to `dir` before it is touched by auto configuration
+func (remote *SerialRemote) backup(ctx context.Context, dir string) error {
+	backup, err := CreateBackup(ctx, remote)
+	if nil != err {
+		return fmt.Errorf("serial:backup: %v", err)
+	}
+
+	path := BackupPath(dir, backup)
+	if err = SaveBackup(backup, path); nil != err {
+		return fmt.Errorf("serial:backup: %v", err)
 	}
 
+	log.Printf("serial:backup: saved %v\n", path)
 	return nil
 }
 
@@ -120,15 +240,34 @@ func (remote *SerialRemote) Channel() chan []byte {
 	return remote.channel
 }
 
-// Close close the serial channel
+// setConfigMode record if the module is in config mode
+func (remote *SerialRemote) setConfigMode(inConfig bool) {
+	if inConfig {
+		atomic.StoreInt32(&remote.inConfig, 1)
+	} else {
+		atomic.StoreInt32(&remote.inConfig, 0)
+	}
+}
+
+// Close close the serial channel, the module is taken out of config mode
+// first so it is not left unable to relay traffic
 func (remote *SerialRemote) Close() error {
+	if 1 == atomic.LoadInt32(&remote.inConfig) {
+		log.Printf("serial:close: leaving config mode\n")
+
+		if _, err := remote.Write([]byte("X"), -1); nil != err {
+			log.Printf("serial:close: failed to leave config mode: %v\n", err)
+		}
+
+		remote.setConfigMode(false)
+	}
+
 	return remote.port.Close()
 }
 
 // Recv attempt to receive maximum amount of bytes within duration `t`
 func (remote *SerialRemote) Recv(t time.Duration) ([]byte, error) {
-	acc := make([]byte, 256)
-	pos := 0
+	acc := make([]byte, 0, 256)
 
 	for {
 		select {
@@ -137,26 +276,118 @@ func (remote *SerialRemote) Recv(t time.Duration) ([]byte, error) {
 				return nil, errors.New("EOF")
 			}
 
-			for i := 0; i < len(buf); i++ {
-				acc[pos+i] = buf[i]
-			}
-
-			pos = pos + len(buf)
+			acc = append(acc, buf...)
 
 		case <-time.After(t):
-			if 0 == pos {
+			if 0 == len(acc) {
 				return []byte(""), nil
 			}
 
-			return acc[:pos], nil
+			return acc, nil
 		}
 	}
 }
 
+// ctsPollInterval how often CTS is checked while the module is busy
+const ctsPollInterval = 1 * time.Millisecond
+
+// default time to wait for CTS when Write is not given a timeout
+const ctsTimeout = 1 * time.Second
+
+// Write write `buf` to the serialport. Writes are paced so the module sees a
+// gap between them and, with rtscts flow control, only sent while the module
+// asserts CTS. Writes longer than the module buffer go out in chunks without
+// a gap in between, as the module would end the packet at a gap
 func (remote *SerialRemote) Write(buf []byte, timeout time.Duration) (int, error) {
-	log.Printf("serial:write[%v]: %v\n", len(buf), buf)
-	bytes, err := remote.port.Write(buf)
-	time.Sleep(UARTTimeout() * 2)
+	debugf("serial:write[%v]: %v\n", len(buf), buf)
+
+	written := 0
+
+	// the gap separates this write from the previous one
+	remote.pace()
+
+	// when the chunk written last has left the UART
+	txDone := time.Now()
+
+	defer func() {
+		remote.idleAt = txDone.Add(remote.writeGap())
+	}()
+
+	for len(buf) > 0 {
+		chunk := buf
+		if limit := remote.flags.Line.ModuleBuffer; limit > 0 && len(chunk) > limit {
+			chunk = chunk[:limit]
+		}
+
+		if wait := time.Until(txDone); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		if FlowRTSCTS == remote.flags.Line.FlowControl {
+			if err := remote.waitCTS(timeout); nil != err {
+				return written, err
+			}
+		}
+
+		bytes, err := remote.port.Write(chunk)
+		written = written + bytes
+		txDone = time.Now().Add(TxTime(remote.mode, bytes))
 
-	return bytes, err
+		if nil != err {
+			return written, err
+		}
+
+		buf = buf[bytes:]
+	}
+
+	return written, nil
+}
+
+// responseTimeout how long to wait for an answer to the last write: until the
+// module has received it and seen the UART timeout, plus `n` characters
+func (remote *SerialRemote) responseTimeout(n int) time.Duration {
+	wait := time.Until(remote.idleAt)
+	if wait < 0 {
+		wait = 0
+	}
+
+	return wait + UARTTimeout(remote.mode) + charTimes(remote.mode, n)
+}
+
+// pace wait until the previous Write has been transmitted and the write gap
+// has passed, returns right away if the line has been idle long enough
+func (remote *SerialRemote) pace() {
+	if wait := time.Until(remote.idleAt); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+func (remote *SerialRemote) writeGap() time.Duration {
+	if gap := remote.flags.Line.WriteGap; gap > 0 {
+		return gap
+	}
+
+	return UARTTimeout(remote.mode)
+}
+
+// waitCTS wait for the module to assert CTS, at most `timeout`
+func (remote *SerialRemote) waitCTS(timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = ctsTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		status, err := remote.port.GetModemStatusBits()
+		if nil != err {
+			return err
+		} else if status.CTS {
+			return nil
+		} else if time.Now().After(deadline) {
+			return fmt.Errorf("serial:write: module did not assert CTS within %v", timeout)
+		}
+
+		time.Sleep(ctsPollInterval)
+	}
 }
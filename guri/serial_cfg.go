@@ -1,14 +1,12 @@
 package guri
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
 )
 
-// timeout
-const byteTimeout = 1000000 / 19200 / 10 * time.Microsecond
-
 // check if we are in conif mode
 func inTinyMeshConfig(remote *SerialRemote) (bool, error) {
 	bytes, err := remote.Write([]byte{255, 255, 255}, -1)
@@ -19,9 +17,9 @@ func inTinyMeshConfig(remote *SerialRemote) (bool, error) {
 		return true, fmt.Errorf("serial:config/inTinyMeshConfig: config mode check failed, unable to write 3 bytes")
 	}
 
-	// open a channel that evetually will return some data
-	// buf, err := readUntilTimeout(port, byteTimeout*2*time.Microsecond)
-	buf, err := remote.Recv(byteTimeout * 2 * time.Microsecond)
+	// open a channel that evetually will return some data, the prompt follows
+	// once the module has seen the UART timeout
+	buf, err := remote.Recv(remote.responseTimeout(20))
 
 	if nil == buf {
 		// if we can't get a response, assume we are in config and do nothing
@@ -31,17 +29,24 @@ func inTinyMeshConfig(remote *SerialRemote) (bool, error) {
 	return len(buf) > 0 && '>' == buf[0], nil
 }
 
-// WaitForTinyMeshConfig wait for `remote` to enter config mode
-func WaitForTinyMeshConfig(remote *SerialRemote) error {
+// WaitForTinyMeshConfig wait for `remote` to enter config mode, gives up
+// when `ctx` is cancelled
+func WaitForTinyMeshConfig(ctx context.Context, remote *SerialRemote) error {
 	inCfg, err := inTinyMeshConfig(remote)
 
 	if nil != err {
 		return err
 	} else if true == inCfg {
+		remote.setConfigMode(true)
 		return nil
 	}
 
-	_, err = remote.Write(SetGwConfigModeCmd([]byte{0, 0, 0, 0}), -1)
+	cmd, err := SetGwConfigModeCmd([]byte{0, 0, 0, 0})
+	if nil != err {
+		return err
+	}
+
+	_, err = remote.Write(cmd, -1)
 
 	if nil != err {
 		return err
@@ -52,12 +57,17 @@ func WaitForTinyMeshConfig(remote *SerialRemote) error {
 	if nil != err {
 		return err
 	} else if true == inCfg {
+		remote.setConfigMode(true)
 		return nil
 	}
 
 	log.Printf("!! Press configuration button to continue\n")
 
 	for {
+		if nil != ctx.Err() {
+			return ctx.Err()
+		}
+
 		buf, err := remote.Recv(50 * time.Millisecond)
 
 		if nil == buf {
@@ -65,12 +75,14 @@ func WaitForTinyMeshConfig(remote *SerialRemote) error {
 		}
 
 		if len(buf) > 0 && '>' == buf[0] {
+			remote.setConfigMode(true)
 			return nil
 		}
 	}
 }
 
-func verifyTinyMeshConfig(remote *SerialRemote, flags Flags) error {
+// leaveTinyMeshConfig make sure `remote` is not in config mode
+func leaveTinyMeshConfig(remote *SerialRemote) error {
 	inCfg, err := inTinyMeshConfig(remote)
 
 	if nil != err {
@@ -85,141 +97,150 @@ func verifyTinyMeshConfig(remote *SerialRemote, flags Flags) error {
 		if nil != err {
 			return err
 		} else if true == inCfg {
-			return fmt.Errorf("serial:config/verifyTinyMeshConfig: failed to exit config mode")
+			return fmt.Errorf("serial:config/leaveTinyMeshConfig: failed to exit config mode")
 		}
 	}
 
+	remote.setConfigMode(false)
+	return nil
+}
+
+// RequestNID ask the gateway on `remote` for its network id, the module must
+// not be in config mode
+func RequestNID(remote *SerialRemote) (GenericEvent, error) {
+	cmd, err := GetNIDCmd([]byte{0, 0, 0, 0})
+	if nil != err {
+		return GenericEvent{}, err
+	}
+
 	// ask for a NID, 1.92 bytes pr. ms
-	tries := 0
-	for {
-		if _, err = remote.Write(GetNIDCmd([]byte{0, 0, 0, 0}), -1); nil != err {
-			return err
+	for tries := 0; tries < 3; tries++ {
+		if _, err := remote.Write(cmd, -1); nil != err {
+			return GenericEvent{}, err
 		}
 
-		// open a channel that evetually will at wait 2 millisecond since last read
+		// open a channel that evetually will at wait 4 characters since last read
 		// to return
-		buf, err := remote.Recv(2 * time.Millisecond)
-
-		if nil != buf {
-			ev, err2 := decode(buf)
-			if nil == err2 && 18 == ev.detail {
-				if !flags.NID.Equal(ev.address) {
-					return fmt.Errorf("serial:config: failed to verify Network ID (%v vs %v)", flags.NID.ToString(), ev.address.ToString())
-				} else if !flags.SID.Equal(ev.sid) {
-					return fmt.Errorf("serial:config: failed to verify System ID (%v vs %v)", flags.SID.ToString(), ev.sid.ToString())
-				} else if !flags.UID.Equal(ev.uid) {
-					return fmt.Errorf("serial:config: failed to verify Unique ID (%v vs %v)", flags.UID.ToString(), ev.uid.ToString())
-				}
-
-				return nil
-
-			} else if nil != err2 || 18 != ev.detail {
-
-				tries = tries + 1
-
-				if tries >= 3 {
-					break
-				}
-			}
-		} else if nil != err {
-			return err
+		buf, err := remote.Recv(remote.responseTimeout(4))
+
+		if nil == buf {
+			return GenericEvent{}, err
+		}
+
+		if ev, err := DecodeEvent(buf); nil == err && EventNID == ev.Detail {
+			return ev, nil
 		}
 	}
 
-	return fmt.Errorf("should never get herssse")
+	return GenericEvent{}, fmt.Errorf("serial:config: no response to get_nid after 3 attempts")
 }
 
-func ensureTinyMeshConfig(remote *SerialRemote, flags Flags) error {
-	// If verifyication is successfull it means we are a gateway with whatever
-	// options specified in flags
-	var err error
-
-	if err = WaitForTinyMeshConfig(remote); nil != err {
+func verifyTinyMeshConfig(ctx context.Context, remote *SerialRemote, flags Flags) error {
+	if err := leaveTinyMeshConfig(remote); nil != err {
 		return err
 	}
 
-	if err = RunConfigCmd(remote, '0', false); err != nil {
-		log.Fatalf("serial:config: failed to request configuration memory: %v", err)
+	if nil != ctx.Err() {
+		return ctx.Err()
 	}
 
-	cfg, err := remote.Recv(255 * time.Millisecond)
+	ev, err := RequestNID(remote)
+
 	if nil != err {
-		log.Fatalf("serial:config: failed to read configuration memory: %v", err)
+		return err
 	}
 
-	if err = RunConfigCmd(remote, 'r', false); err != nil {
-		log.Fatalf("serial:config: failed to request calibration memory: %v", err)
+	if !flags.NID.Equal(ev.Address) {
+		return fmt.Errorf("serial:config: failed to verify Network ID (%v vs %v)", flags.NID.ToString(), ev.Address.ToString())
+	} else if !flags.SID.Equal(ev.SID) {
+		return fmt.Errorf("serial:config: failed to verify System ID (%v vs %v)", flags.SID.ToString(), ev.SID.ToString())
+	} else if !flags.UID.Equal(ev.UID) {
+		return fmt.Errorf("serial:config: failed to verify Unique ID (%v vs %v)", flags.UID.ToString(), ev.UID.ToString())
 	}
 
-	cal, err := remote.Recv(255 * time.Millisecond)
+	return nil
+}
+
+func ensureTinyMeshConfig(ctx context.Context, remote *SerialRemote, flags Flags) (err error) {
+	// If verifyication is successfull it means we are a gateway with whatever
+	// options specified in flags
+	if err = WaitForTinyMeshConfig(ctx, remote); nil != err {
+		return err
+	}
+
+	// never leave the module at the prompt, also when cancelled
+	defer func() {
+		if exitErr := RunConfigCmd(ctx, remote, 'X', false); nil != exitErr && nil == err {
+			err = fmt.Errorf("serial:config: failed to exit configuration mode: %v", exitErr)
+		}
+	}()
+
+	cfg, err := ReadConfigMemory(ctx, remote)
 	if nil != err {
-		return fmt.Errorf("serial:config: failed to read calibration memory: %v", err)
+		return fmt.Errorf("serial:config: %v", err)
 	}
 
-	usingProtocol := cfg[3]
-	deviceType := cfg[14]
-	uid := cfg[45:49]
-	sid := cfg[49:53]
-	nid := cal[23:27]
+	cal, err := ReadCalibrationMemory(ctx, remote)
+	if nil != err {
+		return fmt.Errorf("serial:config: %v", err)
+	}
 
 	log.Printf("serial:config: protocol=%v deviceType=%v uid=%v sid=%v nid=%v",
-		usingProtocol,
-		deviceType,
-		AddressToString(uid),
-		AddressToString(sid),
-		AddressToString(nid))
+		cfg.ProtocolMode,
+		cfg.DeviceType,
+		cfg.UID.ToString(),
+		cfg.SID.ToString(),
+		cal.NID.ToString())
 
-	if 1 != deviceType {
+	if 1 != cfg.DeviceType {
 		log.Println("serial:config: ensure gateway operations")
-		if err = RunConfigCmd(remote, 'G', true); err != nil {
-			log.Fatalf("serial:config: failed to enable gateway mode: %v", err)
+		if err = RunConfigCmd(ctx, remote, 'G', true); err != nil {
+			return fmt.Errorf("serial:config: failed to enable gateway mode: %v", err)
 		}
 	}
 
-	newCfg := []ConfigValue{}
+	newCfg := gatewayConfig(cfg, flags)
 
-	if 0 != usingProtocol {
-		newCfg = append(newCfg, ConfigValue{3, 0})
+	if len(newCfg) > 0 {
+		log.Println("serial:config: set configuration")
+		if err = SetConfigurationMemory(ctx, remote, newCfg); err != nil {
+			return fmt.Errorf("serial:config: failed to set configuration memory %v: %v", newCfg, err)
+		}
 	}
 
-	if !flags.UID.Equal(uid) {
-		newCfg = append(newCfg, ConfigValue{45, flags.UID[0]})
-		newCfg = append(newCfg, ConfigValue{46, flags.UID[1]})
-		newCfg = append(newCfg, ConfigValue{47, flags.UID[2]})
-		newCfg = append(newCfg, ConfigValue{48, flags.UID[3]})
+	if setNID := gatewayCalibration(cal, flags); len(setNID) > 0 {
+		log.Println("serial:config: set calibration")
+		if err = SetCalibrationMemory(ctx, remote, setNID); err != nil {
+			return fmt.Errorf("serial:config: failed to set calibration memory %v: %v", setNID, err)
+		}
 	}
 
-	if !flags.SID.Equal(sid) {
-		newCfg = append(newCfg, ConfigValue{49, flags.SID[0]})
-		newCfg = append(newCfg, ConfigValue{50, flags.SID[1]})
-		newCfg = append(newCfg, ConfigValue{51, flags.SID[2]})
-		newCfg = append(newCfg, ConfigValue{52, flags.SID[3]})
+	return nil
+}
+
+// gatewayConfig configuration memory changes needed to match `flags`
+func gatewayConfig(cfg *ConfigMemory, flags Flags) []ConfigValue {
+	target := cfg.Clone()
+	target.ProtocolMode = 0
+
+	if !flags.UID.Equal(cfg.UID) {
+		target.UID = flags.UID
 	}
 
-	if len(newCfg) > 0 {
-		log.Println("serial:config: set configuration")
-		if err = SetConfigurationMemory(remote, newCfg); err != nil {
-			log.Fatalf("serial:config:failed to set configuration memory: %v\n :: %v\n", newCfg, err)
-		}
+	if !flags.SID.Equal(cfg.SID) {
+		target.SID = flags.SID
 	}
 
-	if !flags.NID.Equal(nid) {
-		setNID := []ConfigValue{
-			ConfigValue{23, flags.NID[0]},
-			ConfigValue{24, flags.NID[1]},
-			ConfigValue{25, flags.NID[2]},
-			ConfigValue{26, flags.NID[3]},
-		}
+	return cfg.Diff(target).ConfigValues()
+}
 
-		log.Println("serial:config: set calibration")
-		if err = SetCalibrationMemory(remote, setNID); err != nil {
-			log.Fatalf("serial:config:failed to set calibration memory: %v\n :: %v\n", setNID, err)
-		}
-	}
+// gatewayCalibration calibration memory changes needed to match `flags`
+func gatewayCalibration(cal *CalibrationMemory, flags Flags) []ConfigValue {
+	target := cal.Clone()
 
-	if err = RunConfigCmd(remote, 'X', false); err != nil {
-		log.Fatalf("serial:config: failed to exit configuration mode: %v", err)
+	if !flags.NID.Equal(cal.NID) {
+		target.NID = flags.NID
 	}
 
-	return nil
+	return cal.Diff(target).ConfigValues()
 }
@@ -0,0 +1,177 @@
+package guri
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	serial "go.bug.st/serial.v1"
+)
+
+// Flow control modes for LineOptions
+const (
+	FlowNone   = "none"
+	FlowRTSCTS = "rtscts"
+)
+
+// DefaultBaud UART speed of a factory configured module
+const DefaultBaud = 19200
+
+// AutoBaudRates speeds probed, in order, when the baud rate is set to auto
+var AutoBaudRates = []int{19200, 115200, 57600, 38400, 9600, 4800, 2400, 76800, 28800, 14400}
+
+// LineOptions serial line settings
+type LineOptions struct {
+	// Baud 0 probes AutoBaudRates until the module answers
+	Baud     int
+	DataBits int
+	// Parity none, odd, even, mark or space
+	Parity string
+	// StopBits 1, 1.5 or 2
+	StopBits string
+	// FlowControl none or rtscts
+	FlowControl string
+	// WriteGap idle time between writes so the module sees them as separate
+	// packets, 0 uses the UART timeout of the module. Lower favours
+	// throughput, higher favours reliability
+	WriteGap time.Duration
+	// ModuleBuffer bytes the module accepts at once, longer writes are split
+	ModuleBuffer int
+}
+
+// DefaultLineOptions 19200 8N1 without flow control
+var DefaultLineOptions = LineOptions{
+	Baud:         DefaultBaud,
+	DataBits:     8,
+	Parity:       "none",
+	StopBits:     "1",
+	FlowControl:  FlowNone,
+	ModuleBuffer: MaxPacketLength,
+}
+
+// ParseBaud parse a baud rate, "auto" gives 0
+func ParseBaud(value string) (int, error) {
+	if "auto" == value {
+		return 0, nil
+	}
+
+	baud, err := strconv.Atoi(value)
+	if nil != err || baud <= 0 {
+		return 0, fmt.Errorf("serial:line: invalid baud rate '%v', must be a positive number or auto", value)
+	}
+
+	return baud, nil
+}
+
+// Mode serial.Mode for the line settings, at `baud` when auto detecting
+func (line LineOptions) Mode(baud int) (*serial.Mode, error) {
+	mode := &serial.Mode{
+		BaudRate: baud,
+		DataBits: line.DataBits,
+	}
+
+	switch strings.ToLower(line.Parity) {
+	case "", "none", "n":
+		mode.Parity = serial.NoParity
+	case "odd", "o":
+		mode.Parity = serial.OddParity
+	case "even", "e":
+		mode.Parity = serial.EvenParity
+	case "mark", "m":
+		mode.Parity = serial.MarkParity
+	case "space", "s":
+		mode.Parity = serial.SpaceParity
+	default:
+		return nil, fmt.Errorf("serial:line: unknown parity '%v', must be none, odd, even, mark or space", line.Parity)
+	}
+
+	switch line.StopBits {
+	case "", "1":
+		mode.StopBits = serial.OneStopBit
+	case "1.5":
+		mode.StopBits = serial.OnePointFiveStopBits
+	case "2":
+		mode.StopBits = serial.TwoStopBits
+	default:
+		return nil, fmt.Errorf("serial:line: invalid stop bits '%v', must be 1, 1.5 or 2", line.StopBits)
+	}
+
+	if 0 == mode.DataBits {
+		mode.DataBits = 8
+	} else if mode.DataBits < 5 || mode.DataBits > 8 {
+		return nil, fmt.Errorf("serial:line: invalid data bits %v, must be 5 to 8", line.DataBits)
+	}
+
+	switch line.FlowControl {
+	case "", FlowNone, FlowRTSCTS:
+	default:
+		return nil, fmt.Errorf("serial:line: unknown flow control '%v', must be none or rtscts", line.FlowControl)
+	}
+
+	return mode, nil
+}
+
+// Validate check the line settings for invalid values
+func (line LineOptions) Validate() error {
+	if line.Baud < 0 {
+		return fmt.Errorf("serial:line: invalid baud rate %v", line.Baud)
+	} else if line.WriteGap < 0 {
+		return fmt.Errorf("serial:line: write gap must not be negative")
+	} else if line.ModuleBuffer < 0 {
+		return fmt.Errorf("serial:line: module buffer must not be negative")
+	}
+
+	_, err := line.Mode(DefaultBaud)
+	return err
+}
+
+// CharTime time it takes to transmit one character with `mode`, start, parity
+// and stop bits included
+func CharTime(mode *serial.Mode) time.Duration {
+	// start bit, and stop bits counted in halves to allow 1.5
+	halfBits := 2 + 2*mode.DataBits
+
+	if serial.NoParity != mode.Parity {
+		halfBits = halfBits + 2
+	}
+
+	switch mode.StopBits {
+	case serial.OnePointFiveStopBits:
+		halfBits = halfBits + 3
+	case serial.TwoStopBits:
+		halfBits = halfBits + 4
+	default:
+		halfBits = halfBits + 2
+	}
+
+	baud := mode.BaudRate
+	if baud <= 0 {
+		baud = DefaultBaud
+	}
+
+	return time.Duration(halfBits) * time.Second / time.Duration(2*baud)
+}
+
+// UARTTimeout time without data after which the module considers a packet
+// complete. The module counts it in characters; 22ms at 19200 baud
+func UARTTimeout(mode *serial.Mode) time.Duration {
+	return charTimes(mode, 42)
+}
+
+// TxTime time it takes to transmit `n` bytes with `mode`
+func TxTime(mode *serial.Mode, n int) time.Duration {
+	return time.Duration(n) * CharTime(mode)
+}
+
+// charTimes `n` character times, but never less than the scheduling
+// granularity of the OS
+func charTimes(mode *serial.Mode, n int) time.Duration {
+	wait := TxTime(mode, n)
+
+	if wait < 2*time.Millisecond {
+		return 2 * time.Millisecond
+	}
+
+	return wait
+}
@@ -9,14 +9,6 @@ import (
 	"go.bug.st/serial.v1/enumerator"
 )
 
-type SerialPort struct {
-	Name         string
-	IsUSB        bool
-	VID          string
-	PID          string
-	SerialNumber string
-}
-
 // PortList return list of available serial ports
 func PortList() ([]SerialPort, error) {
 	ports, err := enumerator.GetDetailedPortsList()
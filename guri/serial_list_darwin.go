@@ -9,6 +9,24 @@ import (
 	serial "go.bug.st/serial.v1"
 )
 
+// PortList return list of available serial ports, USB details are not
+// available on darwin so ports can not be selected by VID/PID
+func PortList() ([]SerialPort, error) {
+	ports, err := serial.GetPortsList()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SerialPort
+
+	for _, name := range ports {
+		results = append(results, SerialPort{Name: name})
+	}
+
+	return results, nil
+}
+
 // darwin needs IOKit to get GetDetailPortsList to work (which in turn required cgo, thus no
 // cross-compiling atm)
 func PrintPortList() {
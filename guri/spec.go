@@ -0,0 +1,235 @@
+package guri
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// RemoteSpec parsed remote specification
+type RemoteSpec struct {
+	// Scheme tcp, tls, unix, listen, file or stdio
+	Scheme string
+	// Address host:port, or a path for unix and file
+	Address string
+	// TLS options for the tls scheme
+	TLS TLSOptions
+	// Proxy for the tcp and tls schemes, see ProxyURL
+	Proxy string
+}
+
+var specSchemes = map[string]bool{
+	"tcp":    true,
+	"tls":    true,
+	"unix":   true,
+	"listen": true,
+	"file":   true,
+	"stdio":  true,
+}
+
+// ParseSpec parse the remote specification `spec`, either a URL or the
+// shorter scheme:address form:
+//
+//	tcp://host:port            plain TCP endpoint, also tcp:host:port
+//	tls://host:port?sni=&ca=   TLS endpoint, also tls:host:port
+//	unix:///path               unix socket, also unix:/path
+//	listen:host:port           accept clients, also listen:unix:/path
+//	file:///path               append frames to a log file, also file:/path
+//	stdio:                     stdin/stdout, also stdio
+//	host:port                  TLS endpoint, or TCP when flags.TLS is false
+//
+// IPv6 hosts are written in brackets, ie. tls://[2001:db8::1]:7002. The query
+// of a tls URL overrides flags.TLSOptions: sni, ca, cert, key, pin-cert,
+// pin-spki, min-version and ciphers. tcp and tls URLs also take proxy, which
+// overrides flags.Proxy
+func ParseSpec(spec string, flags Flags) (RemoteSpec, error) {
+	if "" == spec {
+		return RemoteSpec{}, fmt.Errorf("spec: empty remote")
+	}
+
+	parts := strings.SplitN(spec, ":", 2)
+	remote := RemoteSpec{Scheme: parts[0], TLS: flags.TLSOptions, Proxy: flags.Proxy}
+
+	if !specSchemes[remote.Scheme] {
+		// plain host:port, kept for compatibility with -remote and -tls
+		remote.Scheme = "tcp"
+		if flags.TLS {
+			remote.Scheme = "tls"
+		}
+
+		remote.Address = spec
+	} else if len(parts) > 1 && strings.HasPrefix(parts[1], "//") {
+		if err := remote.parseURL(spec); nil != err {
+			return RemoteSpec{}, err
+		}
+	} else if len(parts) > 1 {
+		remote.Address = parts[1]
+	}
+
+	switch remote.Scheme {
+	case "tcp", "tls":
+		if _, _, err := net.SplitHostPort(remote.Address); nil != err {
+			return RemoteSpec{}, fmt.Errorf("spec: invalid address in %v: %v", spec, err)
+		}
+
+	case "unix", "file", "listen":
+		if "" == remote.Address {
+			return RemoteSpec{}, fmt.Errorf("spec: missing address in %v", spec)
+		}
+	}
+
+	if "tls" == remote.Scheme {
+		if err := remote.TLS.Validate(); nil != err {
+			return RemoteSpec{}, err
+		}
+	}
+
+	if err := ValidateProxy(remote.Proxy); nil != err {
+		return RemoteSpec{}, err
+	}
+
+	return remote, nil
+}
+
+// parseURL fill in address and TLS options from a URL
+func (remote *RemoteSpec) parseURL(spec string) error {
+	u, err := url.Parse(spec)
+	if nil != err {
+		return fmt.Errorf("spec: %v", err)
+	}
+
+	switch remote.Scheme {
+	case "unix", "file":
+		if "" != u.Host {
+			return fmt.Errorf("spec: %v: path must be absolute, ie. %v:///path", spec, remote.Scheme)
+		}
+
+		remote.Address = u.Path
+
+	default:
+		remote.Address = u.Host
+	}
+
+	query, err := parseQuery(u.RawQuery)
+	if nil != err {
+		return fmt.Errorf("spec: %v: %v", spec, err)
+	}
+
+	if "tls" != remote.Scheme && "tcp" != remote.Scheme && len(query) > 0 {
+		return fmt.Errorf("spec: %v: options are only supported for tcp and tls", spec)
+	}
+
+	for key, values := range query {
+		value := strings.Join(values, ",")
+
+		if "proxy" == key {
+			remote.Proxy = values[0]
+			continue
+		} else if "tls" != remote.Scheme {
+			return fmt.Errorf("spec: %v: unknown option %q", spec, key)
+		}
+
+		switch key {
+		case "sni":
+			remote.TLS.ServerName = value
+		case "ca":
+			remote.TLS.CAFile = value
+		case "cert":
+			remote.TLS.CertFile = value
+		case "key":
+			remote.TLS.KeyFile = value
+		case "pin-cert":
+			remote.TLS.PinCert = strings.Split(value, ",")
+		case "pin-spki":
+			remote.TLS.PinSPKI = strings.Split(value, ",")
+		case "min-version":
+			remote.TLS.MinVersion = value
+		case "ciphers":
+			remote.TLS.CipherSuites = strings.Split(value, ",")
+		default:
+			return fmt.Errorf("spec: %v: unknown option %q", spec, key)
+		}
+	}
+
+	return nil
+}
+
+// RedactSpec `spec` without the password of a proxy option, for printing
+func RedactSpec(spec string) string {
+	i := strings.Index(spec, "?")
+	if i < 0 {
+		return spec
+	}
+
+	pairs := strings.Split(spec[i+1:], "&")
+
+	for n, pair := range pairs {
+		if strings.HasPrefix(pair, "proxy=") {
+			value, err := url.PathUnescape(strings.TrimPrefix(pair, "proxy="))
+			if nil != err {
+				value = "xxxxx"
+			}
+
+			pairs[n] = "proxy=" + RedactProxy(value)
+		}
+	}
+
+	return spec[:i+1] + strings.Join(pairs, "&")
+}
+
+// parseQuery like url.ParseQuery, but keeps '+' as is since base64 SPKI pins
+// contain it
+func parseQuery(raw string) (url.Values, error) {
+	values := url.Values{}
+
+	for _, pair := range strings.Split(raw, "&") {
+		if "" == pair {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if 1 == len(kv) {
+			kv = append(kv, "")
+		}
+
+		key, err := url.PathUnescape(kv[0])
+		if nil != err {
+			return nil, err
+		}
+
+		value, err := url.PathUnescape(kv[1])
+		if nil != err {
+			return nil, err
+		}
+
+		values.Add(key, value)
+	}
+
+	return values, nil
+}
+
+// ConnectSpec connect to the remote described by `spec`, see ParseSpec
+func ConnectSpec(ctx context.Context, spec string, flags Flags) (Remote, error) {
+	remote, err := ParseSpec(spec, flags)
+	if nil != err {
+		return nil, err
+	}
+
+	switch remote.Scheme {
+	case "tcp":
+		return ConnectTCP(ctx, remote.Address, remote.Proxy)
+	case "tls":
+		return ConnectTLS(ctx, remote.Address, remote.TLS, remote.Proxy)
+	case "unix":
+		return ConnectUnix(ctx, remote.Address)
+	case "listen":
+		return ConnectListen(ctx, remote.Address, flags.ListenPolicy)
+	case "file":
+		return ConnectFile(remote.Address)
+	}
+
+	return ConnectStdio(os.Stdin, os.Stdout)
+}
@@ -0,0 +1,93 @@
+package guri
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSpec(t *testing.T) {
+	flags := Flags{TLS: true, Proxy: ProxyEnv, TLSOptions: TLSOptions{MinVersion: "1.2", ServerName: "default"}}
+	plain := flags
+	plain.TLS = false
+
+	tests := []struct {
+		spec  string
+		flags Flags
+		want  RemoteSpec
+	}{
+		{"tls://[2001:db8::1]:7002", flags, RemoteSpec{Scheme: "tls", Address: "[2001:db8::1]:7002", TLS: flags.TLSOptions}},
+		{"tls:[2001:db8::1]:7002", flags, RemoteSpec{Scheme: "tls", Address: "[2001:db8::1]:7002", TLS: flags.TLSOptions}},
+		{"[::1]:7002", flags, RemoteSpec{Scheme: "tls", Address: "[::1]:7002", TLS: flags.TLSOptions}},
+		{"[::1]:7002", plain, RemoteSpec{Scheme: "tcp", Address: "[::1]:7002", TLS: flags.TLSOptions}},
+		{"tcp.cloud.tiny-mesh.com:7002", flags, RemoteSpec{Scheme: "tls", Address: "tcp.cloud.tiny-mesh.com:7002", TLS: flags.TLSOptions}},
+		{"tcp://10.0.0.5:7002", flags, RemoteSpec{Scheme: "tcp", Address: "10.0.0.5:7002", TLS: flags.TLSOptions}},
+		{"unix:///var/run/guri.sock", flags, RemoteSpec{Scheme: "unix", Address: "/var/run/guri.sock", TLS: flags.TLSOptions}},
+		{"unix:/var/run/guri.sock", flags, RemoteSpec{Scheme: "unix", Address: "/var/run/guri.sock", TLS: flags.TLSOptions}},
+		{"listen:127.0.0.1:7003", flags, RemoteSpec{Scheme: "listen", Address: "127.0.0.1:7003", TLS: flags.TLSOptions}},
+		{"file:///var/log/frames", flags, RemoteSpec{Scheme: "file", Address: "/var/log/frames", TLS: flags.TLSOptions}},
+		{"stdio:", flags, RemoteSpec{Scheme: "stdio", TLS: flags.TLSOptions}},
+		{
+			"tls://10.0.0.5:7002?sni=tcp.cloud.tiny-mesh.com&ca=/etc/guri/ca.pem&min-version=1.3",
+			flags,
+			RemoteSpec{Scheme: "tls", Address: "10.0.0.5:7002", TLS: TLSOptions{ServerName: "tcp.cloud.tiny-mesh.com", CAFile: "/etc/guri/ca.pem", MinVersion: "1.3"}},
+		},
+		{
+			"tls://[2001:db8::1]:7002?sni=gw.example.com",
+			flags,
+			RemoteSpec{Scheme: "tls", Address: "[2001:db8::1]:7002", TLS: TLSOptions{ServerName: "gw.example.com", MinVersion: "1.2"}},
+		},
+		{
+			"tls://10.0.0.5:7002?pin-spki=" + testSPKIPin + "&pin-spki=" + testSPKIPin,
+			flags,
+			RemoteSpec{Scheme: "tls", Address: "10.0.0.5:7002", TLS: TLSOptions{ServerName: "default", MinVersion: "1.2", PinSPKI: []string{testSPKIPin, testSPKIPin}}},
+		},
+		{
+			"tcp://10.0.0.5:7002?proxy=socks5h://proxy:1080",
+			flags,
+			RemoteSpec{Scheme: "tcp", Address: "10.0.0.5:7002", TLS: flags.TLSOptions, Proxy: "socks5h://proxy:1080"},
+		},
+		{
+			"tls://10.0.0.5:7002?proxy=direct",
+			flags,
+			RemoteSpec{Scheme: "tls", Address: "10.0.0.5:7002", TLS: flags.TLSOptions, Proxy: ProxyDirect},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := ParseSpec(test.spec, test.flags)
+		if nil != err {
+			t.Errorf("%v: %v", test.spec, err)
+		} else if !reflect.DeepEqual(test.want, got) {
+			t.Errorf("%v: parsed as %+v, expected %+v", test.spec, got, test.want)
+		}
+	}
+}
+
+// base64 SHA-256, only the format is checked
+const testSPKIPin = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+func TestParseSpecInvalid(t *testing.T) {
+	flags := Flags{TLS: true, TLSOptions: TLSOptions{MinVersion: "1.2"}}
+
+	for _, spec := range []string{
+		"",
+		"tls://2001:db8::1:7002",
+		"tcp://10.0.0.5",
+		"10.0.0.5",
+		"unix:",
+		"unix://relative/path",
+		"tcp://10.0.0.5:7002?sni=gw.example.com",
+		"tcp://10.0.0.5:7002?ca=/etc/guri/ca.pem",
+		"unix:///var/run/guri.sock?sni=gw.example.com",
+		"file:///var/log/frames?proxy=direct",
+		"tls://10.0.0.5:7002?unknown=1",
+		"tls://10.0.0.5:7002?min-version=1.9",
+		"tls://10.0.0.5:7002?pin-cert=nothex",
+		"tls://10.0.0.5:7002?proxy=ftp://proxy:21",
+		"tls://10.0.0.5:7002?sni=%zz",
+	} {
+		if got, err := ParseSpec(spec, flags); nil == err {
+			t.Errorf("%q: parsed as %+v, expected an error", spec, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package guri
 
 import (
+	"context"
 	"errors"
 	"io"
 	"log"
@@ -25,13 +26,13 @@ func ConnectStdio(input io.Reader, output io.Writer) (*StdioRemote, error) {
 		done:   make(chan interface{}, 2),
 	}
 
-	remote.Connect()
+	remote.Connect(context.Background())
 
 	return remote, nil
 }
 
 // Connect dial into stdio remote
-func (remote *StdioRemote) Connect() error {
+func (remote *StdioRemote) Connect(ctx context.Context) error {
 	data := make(chan []byte, 256)
 
 	go func() {
@@ -79,8 +80,7 @@ func (remote *StdioRemote) Open() chan []byte {
 
 // Recv attempt to receive maximum amount of bytes within duration `t`
 func (remote *StdioRemote) Recv(t time.Duration) ([]byte, error) {
-	acc := make([]byte, 256)
-	pos := 0
+	acc := make([]byte, 0, 256)
 
 	for {
 		select {
@@ -89,24 +89,20 @@ func (remote *StdioRemote) Recv(t time.Duration) ([]byte, error) {
 				return nil, errors.New("EOF")
 			}
 
-			for i := 0; i < len(buf); i++ {
-				acc[pos+i] = buf[i]
-			}
-
-			pos = pos + len(buf)
+			acc = append(acc, buf...)
 
 		case <-time.After(t):
-			if 0 == pos {
-				return []byte(""), errors.New("timeout")
+			if 0 == len(acc) {
+				return []byte(""), nil
 			}
 
-			log.Printf("stdio:recv[%v]: %v", pos, acc[:pos])
-			return acc[:pos], nil
+			debugf("stdio:recv[%v]: %v", len(acc), acc)
+			return acc, nil
 		}
 	}
 }
 
 func (remote *StdioRemote) Write(buf []byte, timeout time.Duration) (int, error) {
-	log.Printf("stdio:write[%v] %v\n", len(buf), buf)
+	debugf("stdio:write[%v] %v\n", len(buf), buf)
 	return remote.writer.Write(buf)
 }
@@ -0,0 +1,275 @@
+package guri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// incomplete packets are discarded when no data has arrived for this long
+const framerIdleTimeout = 250 * time.Millisecond
+
+var errRemoteDown = errors.New("remote down, reconnecting")
+
+// errReconnecting returned by Recv, along with any data read, from remotes
+// that reconnect on their own while they are down
+var errReconnecting = errors.New("reconnecting")
+
+// supervisor owns one remote: it reads and frames incoming data, reconnects
+// the remote on failure using timers instead of sleeping, and reports state
+// changes. Each side of the bridge has its own supervisor so one side
+// reconnecting never stalls the other
+type supervisor struct {
+	name      string
+	remote    Remote
+	timeout   time.Duration
+	backoff   *Backoff
+	reconnect bool
+
+	// frames complete frames read from the remote
+	frames chan []byte
+	// state receives true when the remote is reconnected and false when it is
+	// lost, may be nil
+	state chan bool
+	// ctx stops the supervisor when cancelled
+	ctx context.Context
+	// exited closed once the supervisor has stopped reading
+	exited chan struct{}
+
+	// replaced signalled when the remote was swapped by replace
+	replaced chan struct{}
+	// hotplug when set, reconnecting waits for the device to be present
+	// instead of retrying blindly
+	hotplug *HotplugWatcher
+	// giveUp called when the remote is lost for good, because reconnecting
+	// is disabled or the backoff is exhausted; the supervisor stops reading
+	giveUp func(err error)
+
+	// lock serializes Write and swapping the remote, it is never held while
+	// connecting
+	lock sync.Mutex
+	up   int32
+}
+
+// start supervising an already connected remote
+func (sup *supervisor) start() {
+	atomic.StoreInt32(&sup.up, 1)
+	sup.exited = make(chan struct{})
+	sup.replaced = make(chan struct{}, 1)
+	go sup.run()
+}
+
+func (sup *supervisor) run() {
+	defer close(sup.exited)
+
+	framer := NewFramer(sup.name)
+	last := time.Now()
+
+	for {
+		remote := sup.current()
+		buf, err := remote.Recv(sup.timeout)
+
+		if sup.stopped() {
+			return
+		}
+
+		if nil != err && remote != sup.current() {
+			// replaced while reading, the old remote is closed
+			framer.Flush()
+			continue
+		}
+
+		if errReconnecting == err {
+			// the remote reconnects on its own, only track its state
+			if sup.Up() {
+				log.Printf("%v:close, waiting for it to reconnect\n", sup.name)
+				sup.setUp(false)
+			}
+
+			err = nil
+		} else if nil == err && !sup.Up() {
+			log.Printf("%v:open, reconnected\n", sup.name)
+			sup.setUp(true)
+		}
+
+		if nil != err {
+			if !sup.reconnect {
+				atomic.StoreInt32(&sup.up, 0)
+				sup.giveUp(fmt.Errorf("%v:close: %v, not reconnecting", sup.name, err))
+				return
+			}
+
+			log.Printf("%v:close, reconnecting: %v\n", sup.name, err)
+
+			framer.Flush()
+			sup.setUp(false)
+
+			if !sup.reconnectRemote() {
+				return
+			}
+
+			sup.setUp(true)
+			continue
+		}
+
+		if 0 == len(buf) {
+			if framer.Pending() > 0 && time.Since(last) > framerIdleTimeout {
+				framer.Flush()
+			}
+
+			continue
+		}
+
+		last = time.Now()
+
+		for _, frame := range framer.Push(buf) {
+			select {
+			case sup.frames <- frame:
+			case <-sup.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// reconnectRemote reconnect until it succeeds, returns false if stopped first.
+// The backoff is only used from here, in the run goroutine
+func (sup *supervisor) reconnectRemote() bool {
+	// a replace before the remote was lost is no reason to skip the wait
+	select {
+	case <-sup.replaced:
+	default:
+	}
+
+	for {
+		if nil != sup.hotplug && !sup.hotplug.Present() {
+			log.Printf("%v:open: waiting for %v to be plugged in\n", sup.name, sup.hotplug.uri)
+
+			if nil != sup.hotplug.WaitPresent(sup.ctx) {
+				return false
+			}
+		}
+
+		// connect without holding the lock, a slow connect must not block
+		// current() and replace() which the loop calls
+		remote := sup.current()
+		if sup.Up() {
+			// a connected remote was swapped in by replace
+			sup.backoff.Success()
+			return true
+		}
+
+		remote.Close()
+		err := remote.Connect(sup.ctx)
+
+		if remote != sup.current() {
+			// replaced while connecting, the new remote is already up
+			if nil == err {
+				remote.Close()
+			}
+
+			sup.backoff.Success()
+			return true
+		}
+
+		if nil == err {
+			sup.backoff.Success()
+			log.Printf("%v:open, reconnected\n", sup.name)
+			return true
+		}
+
+		wait, berr := sup.backoff.Next()
+		if nil != berr {
+			sup.giveUp(fmt.Errorf("%v:open: %v, giving up after %v attempts", sup.name, err, sup.backoff.Attempts()))
+			return false
+		}
+
+		log.Printf("%v:open: %v, retrying in %v\n", sup.name, err, wait)
+
+		select {
+		case <-time.After(wait):
+		case <-sup.replaced:
+		case <-sup.ctx.Done():
+			return false
+		}
+	}
+}
+
+// replace swap in the already connected `remote` and close the old one, the
+// other side of the bridge is not touched
+func (sup *supervisor) replace(remote Remote) {
+	sup.lock.Lock()
+	old := sup.remote
+	sup.remote = remote
+	atomic.StoreInt32(&sup.up, 1)
+	sup.lock.Unlock()
+
+	if err := old.Close(); nil != err {
+		log.Printf("%v:close: %v\n", sup.name, err)
+	}
+
+	// wake up a reconnect waiting for its backoff, which is reset there
+	select {
+	case sup.replaced <- struct{}{}:
+	default:
+	}
+}
+
+func (sup *supervisor) current() Remote {
+	sup.lock.Lock()
+	defer sup.lock.Unlock()
+
+	return sup.remote
+}
+
+func (sup *supervisor) setUp(up bool) {
+	if up {
+		atomic.StoreInt32(&sup.up, 1)
+	} else {
+		atomic.StoreInt32(&sup.up, 0)
+	}
+
+	if nil == sup.state {
+		return
+	}
+
+	select {
+	case sup.state <- up:
+	case <-sup.ctx.Done():
+	}
+}
+
+func (sup *supervisor) stopped() bool {
+	select {
+	case <-sup.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// Up true while the remote is connected
+func (sup *supervisor) Up() bool {
+	return 1 == atomic.LoadInt32(&sup.up)
+}
+
+// Write write `buf` to the remote, fails right away while reconnecting
+func (sup *supervisor) Write(buf []byte) (int, error) {
+	if !sup.Up() {
+		return 0, errRemoteDown
+	}
+
+	sup.lock.Lock()
+	defer sup.lock.Unlock()
+
+	return sup.remote.Write(buf, -1)
+}
+
+// Close close the remote, the supervisor must be stopped through `ctx`
+func (sup *supervisor) Close() error {
+	return sup.current().Close()
+}
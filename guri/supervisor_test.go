@@ -0,0 +1,81 @@
+package guri
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// replacing the remote while the supervisor is backing off from failed
+// reconnects, run with -race
+func TestSupervisorReplaceWhileReconnecting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	failing := func() *fakeRemote {
+		return &fakeRemote{closed: true, refuse: true}
+	}
+
+	sup := &supervisor{
+		name:      "test",
+		remote:    failing(),
+		timeout:   time.Millisecond,
+		reconnect: true,
+		frames:    make(chan []byte, 1),
+		ctx:       ctx,
+		backoff:   NewBackoff(BackoffPolicy{Initial: time.Millisecond, Multiplier: 2, Max: 5 * time.Millisecond}),
+	}
+
+	sup.start()
+
+	for i := 0; i < 50; i++ {
+		sup.replace(failing())
+		time.Sleep(time.Millisecond)
+	}
+
+	working := &fakeRemote{}
+	sup.replace(working)
+
+	waitFor(t, "the working remote to be read", func() bool {
+		return sup.Up() && working == sup.current()
+	})
+
+	cancel()
+	<-sup.exited
+}
+
+// an exhausted backoff shuts the loop down cleanly instead of exiting
+func TestLoopGivesUp(t *testing.T) {
+	upstream := &fakeRemote{}
+	downstream := &fakeRemote{}
+
+	flags := Flags{
+		Reconnect: true,
+		Backoff:   BackoffPolicy{Initial: time.Millisecond, Multiplier: 1, Max: time.Millisecond, MaxAttempts: 2},
+		Queue:     QueueOptions{MaxFrames: 10},
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- Loop(context.Background(), upstream, downstream, flags, nil, nil)
+	}()
+
+	upstream.fail()
+
+	select {
+	case err := <-done:
+		if nil == err {
+			t.Fatal("loop stopped without an error")
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop did not give up")
+	}
+
+	downstream.lock.Lock()
+	defer downstream.lock.Unlock()
+
+	if !downstream.closed {
+		t.Fatal("downstream was not closed")
+	}
+}
@@ -1,26 +1,45 @@
 package guri
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net"
 	"time"
 )
 
-// TCPConn remote tcp endpoint config
+// TCPConn remote tcp endpoint config, also used for unix sockets
 type TCPConn struct {
+	network string
 	uri     string
+	proxy   string
 	socket  net.Conn
 	channel chan []byte
 }
 
-// ConnectTCP connect to plain TCP endpoint
-func ConnectTCP(uri string) (*TCPConn, error) {
+// ConnectTCP connect to plain TCP endpoint, through `proxy` if any
+func ConnectTCP(ctx context.Context, uri string, proxy string) (*TCPConn, error) {
 	remote := &TCPConn{
-		uri: uri,
+		network: "tcp",
+		uri:     uri,
+		proxy:   proxy,
 	}
 
-	if err := remote.Connect(); nil != err {
+	if err := remote.Connect(ctx); nil != err {
+		return nil, err
+	}
+
+	return remote, nil
+}
+
+// ConnectUnix connect to a unix socket at `path`
+func ConnectUnix(ctx context.Context, path string) (*TCPConn, error) {
+	remote := &TCPConn{
+		network: "unix",
+		uri:     path,
+	}
+
+	if err := remote.Connect(ctx); nil != err {
 		return nil, err
 	}
 
@@ -28,10 +47,10 @@ func ConnectTCP(uri string) (*TCPConn, error) {
 }
 
 // Connect dial into tcp endpoint
-func (conn *TCPConn) Connect() error {
-	log.Printf("tcp:open uri=%v\n", conn.uri)
+func (conn *TCPConn) Connect(ctx context.Context) error {
+	log.Printf("%v:open uri=%v\n", conn.network, conn.uri)
 
-	socket, err := net.Dial("tcp", conn.uri)
+	socket, err := dialUpstream(ctx, conn.network, conn.uri, conn.proxy)
 
 	if err != nil {
 		return err
@@ -47,15 +66,16 @@ func (conn *TCPConn) Connect() error {
 			}
 		}()
 
-		buf := make([]byte, 256)
-
 		for {
+			// the slice is handed off to the channel, never reuse it
+			buf := make([]byte, 256)
 			n, err := conn.socket.Read(buf)
 
 			if nil != err {
 				log.Printf("error[tcp:recv] %v\n", err)
 				conn.channel <- []byte("")
 				close(conn.channel)
+				return
 			} else {
 				conn.channel <- buf[:n]
 			}
@@ -92,6 +112,6 @@ func (conn *TCPConn) Recv(t time.Duration) ([]byte, error) {
 
 // Write write data to TCP socket
 func (conn *TCPConn) Write(buf []byte, timeout time.Duration) (int, error) {
-	log.Printf("tcp:write[%v] %v\n", len(buf), buf)
+	debugf("tcp:write[%v] %v\n", len(buf), buf)
 	return conn.socket.Write(buf)
 }
@@ -1,27 +1,33 @@
 package guri
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"log"
-	"strings"
+	"net"
 	"time"
 )
 
 // TLSConn information about TLS endpoint
 type TLSConn struct {
 	uri     string
-	socket  tls.Conn
+	opts    TLSOptions
+	proxy   string
+	socket  *tls.Conn
 	channel chan []byte
 }
 
-// ConnectTLS connect to a TLS enabled enpoint
-func ConnectTLS(uri string) (*TLSConn, error) {
+// ConnectTLS connect to a TLS enabled enpoint, through `proxy` if any
+func ConnectTLS(ctx context.Context, uri string, opts TLSOptions, proxy string) (*TLSConn, error) {
 	remote := &TLSConn{
-		uri: uri,
+		uri:   uri,
+		opts:  opts,
+		proxy: proxy,
 	}
 
-	if err := remote.Connect(); nil != err {
+	if err := remote.Connect(ctx); nil != err {
 		return nil, err
 	}
 
@@ -29,20 +35,39 @@ func ConnectTLS(uri string) (*TLSConn, error) {
 }
 
 // Connect tls dialing
-func (conn *TLSConn) Connect() error {
+func (conn *TLSConn) Connect(ctx context.Context) error {
 	log.Printf("tls:open uri=%v (SSL/TLS)\n", conn.uri)
 
-	parts := strings.Split(conn.uri, ":")
-	socket, err := tls.Dial("tcp", conn.uri, &tls.Config{
-		ServerName: parts[0],
-	})
+	host, _, err := net.SplitHostPort(conn.uri)
+	if nil != err {
+		return fmt.Errorf("tls: invalid address %v: %v", conn.uri, err)
+	}
+
+	config, err := conn.opts.config(host)
+	if nil != err {
+		return err
+	}
+
+	// bound the handshake as well as the dial, a stalled server must not
+	// keep the connect hanging
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
 
-	if err != nil {
+	raw, err := dialUpstream(ctx, "tcp", conn.uri, conn.proxy)
+	if nil != err {
 		return err
 	}
 
-	conn.socket = *socket
-	conn.channel = make(chan []byte)
+	// TLS runs end to end, on top of the proxy tunnel if there is one
+	socket := tls.Client(raw, config)
+
+	if err = socket.HandshakeContext(ctx); nil != err {
+		raw.Close()
+		return err
+	}
+
+	conn.socket = socket
+	conn.channel = make(chan []byte, 256)
 
 	go func() {
 		defer func() {
@@ -51,15 +76,16 @@ func (conn *TLSConn) Connect() error {
 			}
 		}()
 
-		buf := make([]byte, 256)
-
 		for {
+			// the slice is handed off to the channel, never reuse it
+			buf := make([]byte, 256)
 			n, err := conn.socket.Read(buf)
 
 			if nil != err {
 				log.Printf("error[tcp/tls:recv] %v\n", err)
 				conn.channel <- []byte("")
 				close(conn.channel)
+				return
 			} else {
 				conn.channel <- buf[:n]
 			}
@@ -95,6 +121,6 @@ func (conn *TLSConn) Recv(t time.Duration) ([]byte, error) {
 }
 
 func (conn *TLSConn) Write(buf []byte, timeout time.Duration) (int, error) {
-	log.Printf("tls:write[%v] %v\n", len(buf), buf)
+	debugf("tls:write[%v] %v\n", len(buf), buf)
 	return conn.socket.Write(buf)
 }
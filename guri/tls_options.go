@@ -0,0 +1,179 @@
+package guri
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// TLSOptions settings for TLS upstreams
+type TLSOptions struct {
+	// CertFile and KeyFile PEM encoded client certificate and key presented to
+	// the server, read again on every connect so rotated files take effect
+	CertFile string
+	KeyFile  string
+	// CAFile PEM bundle trusted instead of the system roots
+	CAFile string
+	// PinCert hex SHA-256 fingerprints of the server certificate
+	PinCert []string
+	// PinSPKI base64 SHA-256 hashes of the public key (SPKI) of any
+	// certificate the server presents
+	PinSPKI []string
+	// MinVersion lowest TLS version accepted: 1.0, 1.1, 1.2 or 1.3
+	MinVersion string
+	// CipherSuites names of the cipher suites offered for TLS 1.2 and older,
+	// empty uses the Go defaults
+	CipherSuites []string
+	// ServerName name sent as SNI and verified against the certificate,
+	// empty uses the host of the remote
+	ServerName string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Validate check the options for missing or conflicting settings
+func (opts TLSOptions) Validate() error {
+	if ("" == opts.CertFile) != ("" == opts.KeyFile) {
+		return errors.New("tls: a client certificate needs both a certificate and a key file")
+	}
+
+	if _, ok := tlsVersions[opts.MinVersion]; !ok && "" != opts.MinVersion {
+		return fmt.Errorf("tls: unknown min version '%v', must be 1.0, 1.1, 1.2 or 1.3", opts.MinVersion)
+	}
+
+	if _, err := cipherSuites(opts.CipherSuites); nil != err {
+		return err
+	}
+
+	for _, pin := range opts.PinCert {
+		if buf, err := hex.DecodeString(normalizeFingerprint(pin)); nil != err || sha256.Size != len(buf) {
+			return fmt.Errorf("tls: invalid certificate pin '%v', must be a hex SHA-256 fingerprint", pin)
+		}
+	}
+
+	for _, pin := range opts.PinSPKI {
+		if buf, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/")); nil != err || sha256.Size != len(buf) {
+			return fmt.Errorf("tls: invalid SPKI pin '%v', must be a base64 SHA-256 hash", pin)
+		}
+	}
+
+	return nil
+}
+
+// config tls.Config for connecting to `host`, files are read on every call
+func (opts TLSOptions) config(host string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: host,
+		MinVersion: tlsVersions[opts.MinVersion],
+	}
+
+	if "" != opts.ServerName {
+		config.ServerName = opts.ServerName
+	}
+
+	if "" != opts.CertFile {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if nil != err {
+			return nil, fmt.Errorf("tls: failed to load client certificate: %v", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if "" != opts.CAFile {
+		buf, err := ioutil.ReadFile(opts.CAFile)
+		if nil != err {
+			return nil, fmt.Errorf("tls: failed to load CA bundle: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("tls: no certificates found in %v", opts.CAFile)
+		}
+
+		config.RootCAs = pool
+	}
+
+	suites, err := cipherSuites(opts.CipherSuites)
+	if nil != err {
+		return nil, err
+	}
+
+	config.CipherSuites = suites
+
+	if len(opts.PinCert) > 0 || len(opts.PinSPKI) > 0 {
+		config.VerifyConnection = opts.verifyPins
+	}
+
+	return config, nil
+}
+
+// verifyPins accept the connection if the server certificate matches a
+// certificate pin, or any presented certificate matches an SPKI pin. Runs
+// after, not instead of, the regular chain verification
+func (opts TLSOptions) verifyPins(state tls.ConnectionState) error {
+	if 0 == len(state.PeerCertificates) {
+		return errors.New("tls: server presented no certificate")
+	}
+
+	leaf := sha256.Sum256(state.PeerCertificates[0].Raw)
+
+	for _, pin := range opts.PinCert {
+		if strings.EqualFold(normalizeFingerprint(pin), hex.EncodeToString(leaf[:])) {
+			return nil
+		}
+	}
+
+	for _, cert := range state.PeerCertificates {
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+		for _, pin := range opts.PinSPKI {
+			if strings.TrimPrefix(pin, "sha256/") == base64.StdEncoding.EncodeToString(spki[:]) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("tls: server certificate %x does not match any pin", leaf)
+}
+
+// normalizeFingerprint accept fingerprints with colons, as printed by openssl
+func normalizeFingerprint(pin string) string {
+	return strings.Replace(pin, ":", "", -1)
+}
+
+// cipherSuites ids of the cipher suites named `names`
+func cipherSuites(names []string) ([]uint16, error) {
+	if 0 == len(names) {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown cipher suite '%v'", name)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
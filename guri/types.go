@@ -1,6 +1,7 @@
 package guri
 
 import (
+	"context"
 	"time"
 )
 
@@ -8,7 +9,7 @@ import (
 type Remote interface {
 	Channel() chan []byte
 	Close() error
-	Connect() error
+	Connect(ctx context.Context) error
 	Recv(timeout time.Duration) ([]byte, error)
 	Write(buf []byte, timeout time.Duration) (int, error)
 }
@@ -19,14 +20,40 @@ type Flags struct {
 	List    bool
 	Version bool
 
-	Verify        bool
-	NID           Address
-	SID           Address
-	UID           Address
-	AutoConfigure bool
+	PrintConfig bool
+	Config      string
+	LogLevel    string
+	LogFile     string
 
-	Stdio     bool
-	Remote    string
-	TLS       bool
-	Reconnect bool
+	Serial          string
+	Line            LineOptions
+	HotplugInterval time.Duration
+
+	Verify             bool
+	NID                Address
+	SID                Address
+	UID                Address
+	AutoConfigure      bool
+	DryRun             bool
+	BackupDir          string
+	Force              bool
+	RestoreCalibration bool
+
+	Stdio      bool
+	Remote     string
+	TLS        bool
+	TLSOptions TLSOptions
+	Proxy      string
+	Reconnect  bool
+
+	FailoverAfter    int
+	FailbackInterval time.Duration
+	Backoff          BackoffPolicy
+
+	Queue   QueueOptions
+	Journal JournalOptions
+
+	Listen       string
+	ListenPolicy string
+	Fanout       []string
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	guri "github.com/tinymesh/guri/guri"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// flags that run a command instead of configuring guri, or override a safety
+// check for a single run, these can not be set from the config file or
+// environment
+var commandFlags = map[string]bool{
+	"help":                true,
+	"list":                true,
+	"version":             true,
+	"print-config":        true,
+	"config":              true,
+	"force":               true,
+	"restore-calibration": true,
+}
+
+// envName environment variable for flag `name`, ie. GURI_QUEUE_FRAMES
+func envName(name string) string {
+	return "GURI_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// mergeSettings apply the -config file and GURI_* environment variables to
+// the flags not given on the command line. Precedence is defaults < config
+// file < environment < flags
+func mergeSettings(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// the config file itself may be given in the environment
+	if value, ok := lookupEnv(envName("config")); ok && !explicit["config"] {
+		fs.Set("config", value)
+	}
+
+	if path := fs.Lookup("config").Value.String(); "" != path {
+		config, err := guri.LoadConfig(path)
+		if nil != err {
+			return err
+		}
+
+		values, err := config.Values()
+		if nil != err {
+			return fmt.Errorf("config: %v: %v", path, err)
+		}
+
+		for name, value := range values {
+			if nil == fs.Lookup(name) || commandFlags[name] {
+				return fmt.Errorf("config: %v: unknown setting %v", path, name)
+			} else if explicit[name] {
+				continue
+			}
+
+			if err = fs.Set(name, value); nil != err {
+				return fmt.Errorf("config: %v: %v: %v", path, name, err)
+			}
+		}
+	}
+
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if nil != err || explicit[f.Name] || commandFlags[f.Name] {
+			return
+		}
+
+		if value, ok := lookupEnv(envName(f.Name)); ok {
+			if setErr := fs.Set(f.Name, value); nil != setErr {
+				err = fmt.Errorf("%v: %v", envName(f.Name), setErr)
+			}
+		}
+	})
+
+	return err
+}
+
+// printConfig write the effective settings as YAML, the output can be used as
+// a -config file once proxy passwords, which are redacted, are filled in
+func printConfig(fs *flag.FlagSet) error {
+	return writeConfig(fs, os.Stdout)
+}
+
+// writeConfig write the effective settings as YAML to `out`
+func writeConfig(fs *flag.FlagSet, out io.Writer) error {
+	settings := map[string]interface{}{}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if commandFlags[f.Name] {
+			return
+		}
+
+		var value interface{} = f.Value.String()
+
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+
+		if duration, ok := value.(time.Duration); ok {
+			value = duration.String()
+		}
+
+		// never print proxy credentials
+		switch f.Name {
+		case "proxy":
+			value = guri.RedactProxy(f.Value.String())
+
+		case "remote", "fanout":
+			specs := strings.Split(f.Value.String(), ",")
+			for i, spec := range specs {
+				specs[i] = guri.RedactSpec(spec)
+			}
+
+			value = strings.Join(specs, ",")
+		}
+
+		settings[strings.Replace(f.Name, "-", "_", -1)] = value
+	})
+
+	buf, err := yaml.Marshal(settings)
+	if nil != err {
+		return err
+	}
+
+	_, err = out.Write(buf)
+	return err
+}